@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/leet"
+	"github.com/oddlid/leetbot_matrix/ltime"
+)
+
+// Backlog is what to do with entries for a round that ended while we were away
+type Backlog string
+
+const (
+	// BacklogDiscard leaves them out, with a note in the log
+	BacklogDiscard Backlog = `discard`
+	// BacklogScore scores them, as if they had been seen in time, in a round of their own, and announces the
+	// results of it when the transport has caught up, or before the next entry is played, whichever comes first.
+	// Only the last round before startup can be scored this way.
+	BacklogScore Backlog = `score`
+)
+
+// catchUpRound is a round that ended while we were away, with the entries to score for it
+type catchUpRound struct {
+	date    time.Time
+	entries []message
+}
+
+// lastRoundEnd returns when the last round played at tf to end before we started ended, including the grace period
+func (b *Bot) lastRoundEnd(tf ltime.TimeFrame) time.Time {
+	started := tf.In(b.started)
+	end := tf.WindowEnd(started).Add(b.cfg.GracePeriod)
+	if end.After(started) {
+		end = tf.WindowEnd(started.AddDate(0, 0, -1)).Add(b.cfg.GracePeriod)
+	}
+	return end
+}
+
+// backlog handles a message that was sent before we started, and returns true if nothing more should be done
+// with it. Such messages are old news, so they get no replies, except for entries in a round that was still
+// going when we started, which are played as usual. Entries for the last round that ended before we
+// started are scored or discarded, according to the backlog policy, and older ones are discarded.
+func (b *Bot) backlog(ctx context.Context, l *leet.Leet, msg message, entry bool) (bool, error) {
+	logger := b.log().With().Str("user", msg.sender).Str("room_id", msg.roomID).Time("sent", msg.sent).Logger()
+	if !entry {
+		logger.Info().Msg("Ignoring command sent before startup")
+		return true, nil
+	}
+	tfr := l.TimeFrame().Code(msg.ts)
+	if !tfr.Code.InsideWindow() {
+		logger.Debug().Msg("Ignoring entry outside the window, sent before startup")
+		return true, nil
+	}
+	roundEnd := tfr.TF.WindowEnd(tfr.TS).Add(b.cfg.GracePeriod)
+	if roundEnd.After(b.started) {
+		return false, nil
+	}
+	if b.cfg.Backlog != BacklogScore || !roundEnd.Equal(b.lastRoundEnd(tfr.TF)) {
+		logger.Info().Str("backlog", string(b.cfg.Backlog)).Msg("Discarding entry for a round that ended while we were away")
+		return true, nil
+	}
+
+	if l.Active() {
+		// the entries of that round can't be told apart from the ones in the round going on now
+		logger.Info().Msg("Discarding entry for a round that ended while we were away, as the next one has started")
+		return true, nil
+	}
+
+	// kept until it can be played in a round of its own, so that it doesn't mix with the round going on now
+	b.mu.Lock()
+	cu := b.catchUp[l]
+	if cu == nil {
+		cu = &catchUpRound{date: tfr.TS}
+		b.catchUp[l] = cu
+	}
+	cu.entries = append(cu.entries, msg)
+	b.mu.Unlock()
+	logger.Info().Msg("Queued entry for a round that ended while we were away")
+	return true, nil
+}
+
+// catchUpGame scores the entries queued for the round of the game that ended while we were away, if any,
+// in a round of its own, and announces the results with the date of that round.
+// A new round is opened when done, which is the one going on now, if any, as it has had no entries yet.
+func (b *Bot) catchUpGame(ctx context.Context, l *leet.Leet, rooms []string) error {
+	b.mu.Lock()
+	cu := b.catchUp[l]
+	delete(b.catchUp, l)
+	b.mu.Unlock()
+	if cu == nil {
+		return nil
+	}
+	defer l.OpenRound()
+
+	l.OpenRound()
+	for _, msg := range cu.entries {
+		// the results tell how it went
+		if _, err := l.Play(ctx, io.Discard, msg.sender, msg.sent, l.TimeFrame().Code(msg.ts)); err != nil {
+			return err
+		}
+	}
+	b.log().Info().Int("entries", len(cu.entries)).Any("rooms", rooms).Msg("Scored entries for a round that ended while we were away")
+	return b.announceRoundOf(ctx, l, rooms, cu.date)
+}
+
+// announceCatchUp announces the results of rounds with entries scored after they ended
+func (b *Bot) announceCatchUp(ctx context.Context) {
+	b.mu.Lock()
+	pending := len(b.catchUp)
+	b.mu.Unlock()
+	if pending == 0 {
+		return
+	}
+
+	for l, rooms := range b.gameRooms() {
+		if err := b.catchUpGame(ctx, l, rooms); err != nil {
+			b.log().Error().Err(err).Any("rooms", rooms).Msg("Failed to announce results of round missed while away")
+		}
+	}
+}
+
+// catchUpBeforePlay announces the results of the round of the game missed while away, if any,
+// before an entry for the next round is played
+func (b *Bot) catchUpBeforePlay(ctx context.Context, l *leet.Leet) {
+	b.mu.Lock()
+	_, pending := b.catchUp[l]
+	b.mu.Unlock()
+	if !pending {
+		return
+	}
+	rooms := b.gameRooms()[l]
+	if err := b.catchUpGame(ctx, l, rooms); err != nil {
+		b.log().Error().Err(err).Any("rooms", rooms).Msg("Failed to announce results of round missed while away")
+	}
+}
+
+// HandleCaughtUp implements Handler
+func (b *Bot) HandleCaughtUp(ctx context.Context) {
+	b.log().Debug().Msg("Caught up")
+	b.announceCatchUp(ctx)
+}
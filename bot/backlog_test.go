@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/leet"
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Bot_backlog_restartInWindow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	today := time.Date(2025, 5, 12, 13, 37, 0, 0, time.UTC)
+	yesterday := today.AddDate(0, 0, -1)
+	clock := ltime.NewFakeClock(today.Add(30 * time.Second))
+	var buf strings.Builder
+	b := New(
+		BotConfig{
+			TimeFrame: ltime.TimeFrame{Hour: 13, Minute: 37, WindowBefore: time.Minute, WindowAfter: time.Minute},
+			Backlog:   BacklogScore,
+			Trust:     TrustPolicy{MaxSkew: 10 * time.Second}, // the default
+			Clock:     clock,
+		},
+		NewConsoleTransport(nil, &buf, "alice", clock),
+		zerolog.Nop(),
+	)
+	// restarted in the middle of today's window
+	b.started = clock.Now()
+	b.HandleJoin(ctx, consoleRoom)
+	entry := func(sender string, sent time.Time) {
+		b.HandleMessage(ctx, Message{RoomID: consoleRoom, Sender: sender, Body: "!1337", Sent: sent, Received: clock.Now()})
+	}
+
+	// what was sent yesterday while we were away is kept for later
+	entry("alice", yesterday.Add(100*time.Millisecond))
+	entry("bob", yesterday.Add(200*time.Millisecond))
+	assert.Empty(t, buf.String())
+
+	// and announced, with its own date, before today's first entry is played
+	entry("alice", today.Add(300*time.Millisecond))
+	out := buf.String()
+	results := strings.Index(out, "Results for 2025-05-11:")
+	require.GreaterOrEqual(t, results, 0, out)
+	assert.Contains(t, out[results:], "#1 alice [13:37:00:100000000] +3 = 3")
+	assert.Contains(t, out[results:], "#2 bob [13:37:00:200000000] +3 = 3")
+	reply := strings.Index(out, "alice - +")
+	assert.Greater(t, reply, results, out)
+	assert.NotContains(t, out, "Stop spamming")
+	// it reached us late because we were away, not because of the sender's server
+	assert.NotContains(t, out, "Note:")
+
+	// catching up doesn't close today's round
+	buf.Reset()
+	b.HandleCaughtUp(ctx)
+	assert.Empty(t, buf.String())
+	l := b.game(ctx, b.modes[0], consoleRoom)
+	assert.Equal(t, leet.RoundCollecting, l.State())
+
+	clock.Set(today.Add(40 * time.Second))
+	entry("bob", today.Add(400*time.Millisecond))
+	assert.Contains(t, buf.String(), "bob - +")
+	assert.NotContains(t, buf.String(), "Stop spamming")
+	assert.NotContains(t, buf.String(), "Note:")
+
+	// while entries sent after we started are still checked
+	buf.Reset()
+	clock.Set(today.Add(50 * time.Second))
+	entry("carol", today.Add(35*time.Second))
+	assert.Contains(t, buf.String(), "carol - +")
+	assert.Contains(t, buf.String(), "(Note: your entry reached me 15s off")
+
+	buf.Reset()
+	require.NoError(t, b.announceRound(ctx, l, []string{consoleRoom}))
+	out = buf.String()
+	assert.Contains(t, out, "Results for 2025-05-12:")
+	assert.Contains(t, out, "#1 alice [13:37:00:300000000] +3 = 6")
+	assert.Contains(t, out, "#2 bob [13:37:00:400000000] +3 = 6")
+}
@@ -1,13 +1,13 @@
 package bot
 
-// See eample at: https://github.com/mautrix/go/blob/main/example/main.go
-
 import (
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -16,71 +16,288 @@ import (
 	"github.com/oddlid/leetbot_matrix/util"
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog"
-	"maunium.net/go/mautrix"
-	"maunium.net/go/mautrix/crypto/cryptohelper"
-	"maunium.net/go/mautrix/event"
-	"maunium.net/go/mautrix/id"
 )
 
 const (
-	subCmdStats  = `stats`
-	subCmdReload = `reload`
+	subCmdStats    = `stats`
+	subCmdReload   = `reload`
+	subCmdHistory  = `history`
+	subCmdHelp     = `help`
+	defaultHistory = 5
+	// seenRetention is how long handled messages are remembered, which must be longer than
+	// how far back a replayed sync might go
+	seenRetention = 30 * 24 * time.Hour
+)
+
+// Feedback is how the bot responds to entries
+type Feedback string
+
+const (
+	// FeedbackReply replies to each entry with how it went
+	FeedbackReply Feedback = `reply`
+	// FeedbackReact reacts to each entry with an emoji, and leaves the details to the round results.
+	// Transports that can't react get a reply instead.
+	FeedbackReact Feedback = `react`
+)
+
+// Reactions to entries, with FeedbackReact
+const (
+	reactScored   = "✅"
+	reactMissed   = "⏰"
+	reactSpam     = "🚫"
+	reactDone     = "🏁"
+	reactClosed   = "🔒"
+	reactRejected = "⛔"
+	reactFlagged  = "⚠️"
 )
 
 var (
 	ErrNilClient   = errors.New("client is nil")
 	ErrNilReceiver = errors.New("receiver is nil")
 	ErrNoRoomID    = errors.New("no room ID set")
+	ErrNoGame      = errors.New("no game for room")
+	ErrNoTransport = errors.New("no transport")
 )
 
+// GameConfig is a game played alongside the main one, in the same rooms, with its own rounds and leaderboard.
+// Its command is given by the target time, like "!2222".
+type GameConfig struct {
+	Name      string // tells the game apart in storage and config files, so it must be unique, and never change
+	TimeFrame ltime.TimeFrame
+}
+
 type BotConfig struct {
-	Username   string
-	Password   string
-	Server     string
+	Server     string // the bot's own server, which is always trusted
 	Room       string
 	DBPath     string
 	ConfigFile string
-	TimeFrame  ltime.TimeFrame
+	TimeFrame  ltime.TimeFrame // when the main game is played
+	// Games are more games to play, besides the main one
+	Games []GameConfig
+	// GracePeriod is how long after the entry window has closed that we still accept entries
+	// with a timestamp inside the window, since events from other servers might arrive late.
+	// When set, provisional results are posted when the window closes, and then edited with the
+	// final results when the grace period ends.
+	GracePeriod time.Duration
+	Trust       TrustPolicy
+	// SharedLeaderboard makes all rooms play the same game, instead of each room having its own
+	SharedLeaderboard bool
+	// Clock is where the time comes from, if nil, the system clock is used
+	Clock ltime.Clock
+	// Scheduler runs the round jobs, if nil, a cron scheduler on the system clock is used.
+	// Set it to a FakeCron along with a fake Clock to control when rounds happen.
+	Scheduler Scheduler
+	// Feedback is how entries are responded to, FeedbackReply if not set
+	Feedback Feedback
+	// Backlog is what to do with entries for the last round, that are first seen after it ended,
+	// because we were away. BacklogDiscard if not set.
+	Backlog Backlog
 }
-type Bot struct {
-	client  *mautrix.Client
-	cron    *cron.Cron
-	leet    *leet.Leet
+
+// message is an incoming message, with everything needed to handle it
+type message struct {
+	id       string // transport specific, if any
+	roomID   string
+	sender   string
+	body     string
+	sent     time.Time   // origin_server_ts, as given by the sender's server
+	received time.Time   // when we got it
+	ts       time.Time   // sent, adjusted with sub-millisecond precision from the time of receipt
+	trust    trustResult // how far the timestamp can be trusted, only checked for entries
+	edited   bool
+}
+
+// gameMode is one of the games played in each room, and the command that messages for it start with
+type gameMode struct {
+	name    string // "" for the main game, which keeps the keys and config files from before there were more
 	command string
-	userID  string
-	cfg     BotConfig
-	logger  zerolog.Logger
+	tf      ltime.TimeFrame
+}
+
+func newGameMode(name string, tf ltime.TimeFrame) *gameMode {
+	return &gameMode{
+		name:    name,
+		command: fmt.Sprintf("!%02d%02d", tf.Hour, tf.Minute),
+		tf:      tf,
+	}
+}
+
+// modeRoom identifies the game of a mode in a room
+type modeRoom struct {
+	mode   string
+	roomID string
+}
+
+// gameRoom is a game in one of the rooms it's played in
+type gameRoom struct {
+	game   *leet.Leet
+	roomID string
+}
+
+type Bot struct {
+	transport      Transport
+	cron           Scheduler
+	clock          ltime.Clock
+	store          *leet.Store
+	modes          []*gameMode // the main game first
+	router         *router
+	games          map[modeRoom]*leet.Leet
+	shared         map[string]*leet.Leet // the game of each mode for all rooms, when the leaderboard is shared
+	userID         string
+	cfg            BotConfig
+	logger         zerolog.Logger
+	trust          *trustChecker
+	provisionalIDs map[gameRoom]string          // messages with provisional results, to be edited with the final results
+	catchUp        map[*leet.Leet]*catchUpRound // games with entries to score for a round missed while away
+	started        time.Time
+	gamesMu        sync.RWMutex // guards games and shared
+	mu             sync.Mutex   // guards provisionalIDs and catchUp
+}
+
+// New creates a bot playing the game over the given transport
+func New(cfg BotConfig, transport Transport, logger zerolog.Logger) *Bot {
+	b := &Bot{
+		transport:      transport,
+		cfg:            cfg,
+		modes:          []*gameMode{newGameMode("", cfg.TimeFrame)},
+		router:         newRouter(),
+		logger:         logger,
+		games:          make(map[modeRoom]*leet.Leet),
+		shared:         make(map[string]*leet.Leet),
+		trust:          newTrustChecker(cfg.Trust, cfg.Server),
+		provisionalIDs: make(map[gameRoom]string),
+		catchUp:        make(map[*leet.Leet]*catchUpRound),
+		clock:          ltime.ClockOrReal(cfg.Clock),
+		cron:           cfg.Scheduler,
+	}
+	for _, g := range cfg.Games {
+		b.modes = append(b.modes, newGameMode(g.Name, g.TimeFrame))
+	}
+	if transport != nil {
+		b.userID = transport.UserID()
+	}
+	return b
+}
+
+// checkModes makes sure that the games can be told apart, both in messages and in storage
+func (b *Bot) checkModes() error {
+	names := make(map[string]bool, len(b.modes))
+	commands := make(map[string]bool, len(b.modes))
+	for i, m := range b.modes {
+		if i > 0 && !validModeName(m.name) {
+			return fmt.Errorf("invalid game name %q, use letters, digits, '-' and '_'", m.name)
+		}
+		if names[m.name] {
+			return fmt.Errorf("more than one game named %q", m.name)
+		}
+		if commands[m.command] {
+			return fmt.Errorf("more than one game with the command %q", m.command)
+		}
+		names[m.name] = true
+		commands[m.command] = true
+	}
+	return nil
+}
+
+func validModeName(name string) bool {
+	return name != "" && strings.IndexFunc(
+		name,
+		func(r rune) bool {
+			return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_'
+		},
+	) == -1
 }
 
-func New(cfg BotConfig, logger zerolog.Logger) *Bot {
-	return &Bot{
-		cfg:     cfg,
-		command: fmt.Sprintf("!%d%d", cfg.TimeFrame.Hour, cfg.TimeFrame.Minute),
-		userID:  fmt.Sprintf("@%s:%s", cfg.Username, cfg.Server),
-		logger:  logger, // adjust later
-		leet:    leet.New(logger, cfg.ConfigFile, cfg.Room, cfg.TimeFrame),
+// modeFor returns the game mode with the given command, or nil if there's none
+func (b *Bot) modeFor(command string) *gameMode {
+	for _, m := range b.modes {
+		if m.command == command {
+			return m
+		}
 	}
+	return nil
 }
 
 func (b *Bot) log() *zerolog.Logger {
 	return &b.logger
 }
 
-func (b *Bot) scheduleConfigSave() error {
+func (b *Bot) saveGames(ctx context.Context) {
+	for l, rooms := range b.gameRooms() {
+		b.log().Debug().Any("rooms", rooms).Msg("Saving game...")
+		if err := l.Save(ctx); err != nil {
+			b.log().Error().Err(err).Any("rooms", rooms).Msg("Failed to save game!")
+		}
+	}
+}
+
+// scheduleRound adds cron jobs for the rounds of each game, see scheduleMode
+func (b *Bot) scheduleRound(ctx context.Context) error {
 	if b.cron == nil {
 		b.cron = cron.New(cron.WithSeconds())
 	}
 
-	llog := b.log().With().Str("config_file", b.cfg.ConfigFile).Logger()
-	cronSpec := b.cfg.TimeFrame.Adjust(time.Now(), 3*time.Minute).AsCronSpec()
-	llog.Debug().Str("cron_spec", cronSpec).Msg("Adding cron job for saving config")
+	for _, m := range b.modes {
+		if err := b.scheduleMode(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scheduleMode adds cron jobs for opening the rounds of the games of the mode when the entry window opens,
+// and for closing them and announcing the results when the entry window closes.
+// If there's a grace period, provisional results are announced when the window closes,
+// and the final results when the grace period is over.
+func (b *Bot) scheduleMode(ctx context.Context, m *gameMode) error {
+	now := b.clock.Now()
+	tf := m.tf
+	openSpec := ltime.CronSpecAt(tf.Target(now).Add(-tf.WindowBefore))
+	closeSpec := ltime.CronSpecAt(tf.WindowEnd(now))
+	finalSpec := ltime.CronSpecAt(tf.WindowEnd(now).Add(b.cfg.GracePeriod))
+	b.log().Debug().
+		Str("game", m.name).
+		Str("open_spec", openSpec).
+		Str("close_spec", closeSpec).
+		Str("final_spec", finalSpec).
+		Msg("Adding cron jobs for round")
+
+	if _, err := b.cron.AddFunc(
+		openSpec,
+		func() {
+			// in case the transport never said it was done catching up
+			b.announceCatchUp(ctx)
+			for l := range b.gameRoomsOf(m) {
+				l.OpenRound()
+			}
+			b.forgetSeen(ctx)
+		},
+	); err != nil {
+		return err
+	}
+
+	if b.cfg.GracePeriod > 0 {
+		if _, err := b.cron.AddFunc(
+			closeSpec,
+			func() {
+				for l, rooms := range b.gameRoomsOf(m) {
+					if err := b.announceProvisional(ctx, l, rooms); err != nil {
+						b.log().Error().Err(err).Str("game", m.name).Any("rooms", rooms).Msg("Failed to announce provisional round results")
+					}
+				}
+			},
+		); err != nil {
+			return err
+		}
+	}
 
 	_, err := b.cron.AddFunc(
-		cronSpec,
+		finalSpec,
 		func() {
-			llog.Debug().Msg("Saving config file...")
-			if err := b.leet.SaveConfigFile(); err != nil {
-				llog.Error().Err(err).Msg("Failed to save config!")
+			for l, rooms := range b.gameRoomsOf(m) {
+				if err := b.announceRound(ctx, l, rooms); err != nil {
+					b.log().Error().Err(err).Str("game", m.name).Any("rooms", rooms).Msg("Failed to announce round results")
+				}
 			}
 		},
 	)
@@ -88,6 +305,77 @@ func (b *Bot) scheduleConfigSave() error {
 	return err
 }
 
+// announceProvisional announces the provisional results of the game to all the rooms it's played in
+func (b *Bot) announceProvisional(ctx context.Context, l *leet.Leet, rooms []string) error {
+	rr, err := l.ProvisionalResults(l.TimeFrame().In(b.clock.Now()))
+	if err != nil {
+		return err
+	}
+	if rr.Empty() {
+		b.log().Debug().Any("rooms", rooms).Msg("No entries yet, no provisional results to announce")
+		return nil
+	}
+	var errs []error
+	for _, roomID := range rooms {
+		content, err := b.reportContent(ctx, roomID, rr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		evtID, err := b.transport.Send(ctx, roomID, content)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		b.mu.Lock()
+		b.provisionalIDs[gameRoom{l, roomID}] = evtID
+		b.mu.Unlock()
+	}
+
+	return errors.Join(errs...)
+}
+
+// announceRound closes the round of the game and announces the results to all the rooms it's played in.
+// If provisional results were announced, that message is edited to show the final results instead.
+func (b *Bot) announceRound(ctx context.Context, l *leet.Leet, rooms []string) error {
+	return b.announceRoundOf(ctx, l, rooms, l.TimeFrame().In(b.clock.Now()))
+}
+
+// announceRoundOf closes the round, which is for the given date, and announces the results
+func (b *Bot) announceRoundOf(ctx context.Context, l *leet.Leet, rooms []string, date time.Time) error {
+	rr, err := l.CloseRound(ctx, date)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, roomID := range rooms {
+		b.mu.Lock()
+		provisionalID := b.provisionalIDs[gameRoom{l, roomID}]
+		delete(b.provisionalIDs, gameRoom{l, roomID})
+		b.mu.Unlock()
+
+		if rr.Empty() {
+			continue
+		}
+		content, err := b.reportContent(ctx, roomID, rr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if provisionalID != "" {
+			errs = append(errs, b.edit(ctx, roomID, provisionalID, content))
+		} else {
+			errs = append(errs, b.sendContent(ctx, roomID, content))
+		}
+	}
+
+	if rr.Empty() {
+		b.log().Debug().Any("rooms", rooms).Msg("No entries this round, nothing to announce")
+	}
+	return errors.Join(errs...)
+}
+
 func (b *Bot) fromSelf(user string) bool {
 	if b == nil {
 		return false
@@ -95,47 +383,76 @@ func (b *Bot) fromSelf(user string) bool {
 	return user != "" && b.userID != "" && user == b.userID
 }
 
-func (b *Bot) setRoom(roomID id.RoomID) {
-	if b == nil {
-		return
+// names returns how players are shown in the room
+func (b *Bot) names(ctx context.Context, roomID string) leet.NameFunc {
+	if b.transport == nil {
+		return nil
 	}
-	if err := b.leet.SetRoom(roomID.String()); err != nil {
-		b.log().Error().Err(err).Str("room_id", roomID.String()).Msg("Failed to update room ID")
+	return func(userID string) string {
+		return b.transport.DisplayName(ctx, roomID, userID)
 	}
 }
 
-func (b *Bot) send(ctx context.Context, msg string) error {
-	room, err := b.leet.GetRoom()
-	if err != nil {
-		return err
+// reportContent renders the report for the room both as plain text and HTML,
+// and leaves it to the transport to pick one
+func (b *Bot) reportContent(ctx context.Context, roomID string, r leet.Report) (Content, error) {
+	names := b.names(ctx, roomID)
+	var text, html strings.Builder
+	if err := r.WriteText(&text, names); err != nil {
+		return Content{}, err
 	}
-	if room == "" {
-		return ErrNoRoomID
+	if err := r.WriteHTML(&html, names); err != nil {
+		return Content{}, err
 	}
+	return Content{Text: text.String(), HTML: html.String()}, nil
+}
 
-	if b.client == nil {
-		return ErrNilClient
+func (b *Bot) sendContent(ctx context.Context, roomID string, content Content) error {
+	if b.transport == nil {
+		return ErrNoTransport
 	}
-
-	_, err = b.client.SendText(ctx, id.RoomID(room), msg)
+	_, err := b.transport.Send(ctx, roomID, content)
 	return err
 }
 
-func (b *Bot) getStats(_ context.Context, w io.Writer) error {
-	if b.leet.Active() {
-		if err := util.Fpf(w, "Calculation in progress, please try later"); err != nil {
-			return err
-		}
+func (b *Bot) send(ctx context.Context, roomID string, msg string) error {
+	return b.sendContent(ctx, roomID, Content{Text: msg})
+}
+
+// edit replaces the content of a previously sent message
+func (b *Bot) edit(ctx context.Context, roomID, original string, content Content) error {
+	if b.transport == nil {
+		return ErrNoTransport
+	}
+	return b.transport.Edit(ctx, roomID, original, content)
+}
+
+func (b *Bot) getStats(ctx context.Context, roomID string, l *leet.Leet) error {
+	if l.Calculating() {
+		return b.send(ctx, roomID, "Calculation in progress, please try later")
+	}
+	stats, err := l.Stats()
+	if err != nil {
+		return err
 	}
-	return b.leet.Stats(w)
+	content, err := b.reportContent(ctx, roomID, stats)
+	if err != nil {
+		return err
+	}
+	return b.sendContent(ctx, roomID, content)
 }
 
-func (b *Bot) reloadConfig(_ context.Context, w io.Writer) error {
-	if b.leet.Active() {
-		return util.Fpf(w, "Calculation in progress, please try later")
+func (b *Bot) reloadConfig(ctx context.Context, w io.Writer, l *leet.Leet) error {
+	// Reloading in the middle of a round would wipe the entries received so far
+	if l.Active() {
+		return util.Fpf(w, "Round in progress, please try later")
 	}
 
-	err := b.leet.LoadConfigFile()
+	// Load would prefer the database, so read the file and import it, to replace what's stored
+	err := l.LoadConfigFile()
+	if err == nil {
+		err = l.Save(ctx)
+	}
 	if err != nil {
 		if err := util.Fpf(w, "Failed to reload config. Please check logs."); err != nil {
 			return err
@@ -148,13 +465,53 @@ func (b *Bot) reloadConfig(_ context.Context, w io.Writer) error {
 	return err
 }
 
-func (b *Bot) play(ctx context.Context, w io.Writer, ts time.Time, user string) error {
-	tfr := b.cfg.TimeFrame.Code(ts)
+func (b *Bot) history(ctx context.Context, w io.Writer, l *leet.Leet, command, sender string, args []string) error {
+	user := sender
+	n := defaultHistory
+	var err error
+	switch len(args) {
+	case 0:
+	case 1:
+		// either a number or a user
+		if num, convErr := strconv.Atoi(args[0]); convErr == nil {
+			n = num
+		} else {
+			user = args[0]
+		}
+	default:
+		user = args[0]
+		n, err = strconv.Atoi(args[1])
+	}
+	if err != nil || n < 1 {
+		return util.Fpf(w, "Usage: %s %s %s", command, subCmdHistory, historyArgs)
+	}
+
+	if err = l.History(ctx, w, user, n); errors.Is(err, leet.ErrNoHistory) {
+		return util.Fpf(w, "History is not available")
+	}
+	return err
+}
+
+// outcomeReactions are the reactions for what came of entries that were passed on to the game
+var outcomeReactions = map[leet.Outcome]string{
+	leet.OutcomeScored: reactScored,
+	leet.OutcomeMissed: reactMissed,
+	leet.OutcomeSpam:   reactSpam,
+	leet.OutcomeDone:   reactDone,
+	leet.OutcomeClosed: reactClosed,
+}
+
+// play handles an entry, and writes the reply to w. It returns the reaction to use instead of the reply,
+// when reacting to entries.
+func (b *Bot) play(ctx context.Context, w io.Writer, l *leet.Leet, msg message) (string, error) {
+	user := msg.sender
+	trust := msg.trust
+	tfr := l.TimeFrame().Code(msg.ts)
 	if !tfr.Code.InsideWindow() {
 		if err := ltime.FormatTimeStampFull(w, tfr.TS); err != nil {
-			return err
+			return "", err
 		}
-		return util.Fpf(
+		return reactMissed, util.Fpf(
 			w,
 			": Check your time, %s! I will only respond to this command between %s and %s.",
 			user,
@@ -163,140 +520,240 @@ func (b *Bot) play(ctx context.Context, w io.Writer, ts time.Time, user string)
 		)
 	}
 
-	return b.leet.Play(ctx, w, user, tfr)
+	if trust.verdict == trustRejected {
+		if err := ltime.FormatTimeStampFull(w, tfr.TS); err != nil {
+			return "", err
+		}
+		return reactRejected, util.Fpf(w, ": Sorry %s, %s.", user, trust.reason)
+	}
+
+	outcome, err := l.Play(ctx, w, user, msg.sent, tfr)
+	if err != nil {
+		return "", err
+	}
+
+	if trust.verdict == trustFlagged {
+		return outcomeReactions[outcome], util.Fpf(w, " (Note: %s)", trust.reason)
+	}
+	return outcomeReactions[outcome], nil
+}
+
+// feedback tells the player how the entry went, by a reply, or by a reaction if so configured and
+// the transport can do it. A flagged entry gets an extra reaction, as the note is not shown.
+func (b *Bot) feedback(ctx context.Context, msg message, reply, reaction string) error {
+	if b.cfg.Feedback == FeedbackReact && b.transport != nil && msg.id != "" && reaction != "" {
+		err := b.transport.React(ctx, msg.roomID, msg.id, reaction)
+		if err == nil && msg.trust.verdict == trustFlagged {
+			err = b.transport.React(ctx, msg.roomID, msg.id, reactFlagged)
+		}
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	// the reply is addressed to the player
+	return b.sendContent(ctx, msg.roomID, Content{Text: reply, Mentions: []string{msg.sender}, ReplyTo: msg.id})
+}
+
+// seenBefore remembers the message, and returns true if it has already been handled, as when a sync is replayed
+// after a restart. Messages without IDs can't be told apart, so they are never seen before.
+func (b *Bot) seenBefore(ctx context.Context, msg message, entry bool) bool {
+	if msg.id == "" || b.store == nil {
+		return false
+	}
+	seen, err := b.store.MarkSeen(
+		ctx,
+		leet.SeenMessage{
+			ID:     msg.id,
+			Room:   msg.roomID,
+			Sender: msg.sender,
+			Entry:  entry,
+			Seen:   b.clock.Now(),
+		},
+	)
+	if err != nil {
+		// handling it twice is better than not at all
+		b.log().Error().Err(err).Str("message_id", msg.id).Msg("Failed to remember message")
+		return false
+	}
+	return seen
+}
+
+// forgetSeen forgets messages that are too old to be replayed
+func (b *Bot) forgetSeen(ctx context.Context) {
+	if b.store == nil {
+		return
+	}
+	n, err := b.store.ForgetSeen(ctx, b.clock.Now().Add(-seenRetention))
+	if err != nil {
+		b.log().Error().Err(err).Msg("Failed to forget old messages")
+		return
+	}
+	b.log().Debug().Int64("count", n).Msg("Forgot old messages")
 }
 
-func (b *Bot) dispatch(ctx context.Context, ts time.Time, user, cmd string) error {
+func (b *Bot) dispatch(ctx context.Context, msg message) error {
 	if b == nil {
 		return ErrNilReceiver
 	}
 
+	user := msg.sender
+	cmd := msg.body
+	roomID := msg.roomID
+
 	if b.fromSelf(user) {
 		b.log().Debug().Str("user", user).Msg("Ignoring message from myself")
 		return nil
 	}
-	if !strings.HasPrefix(cmd, b.command) {
+	// the command must be a word of its own, so that "!13370" is not taken for "!1337"
+	fields := strings.Fields(cmd)
+	var mode *gameMode
+	if len(fields) > 0 {
+		mode = b.modeFor(fields[0])
+	}
+	if mode == nil {
 		b.log().Debug().Str("user", user).Str("msg", cmd).Msg("Ignoring message without required prefix")
 		return nil
 	}
+	entry := len(fields) == 1
+	b.log().Debug().Strs("fields", fields).Str("room_id", roomID).Str("game", mode.name).Send()
 
-	cmds := strings.Split(cmd, " ")
-	b.log().Debug().Strs("cmds", cmds).Send()
+	l := b.game(ctx, mode, roomID)
+	if l == nil {
+		return ErrNoGame
+	}
 
-	var buf strings.Builder
+	if b.seenBefore(ctx, msg, entry) {
+		b.log().Info().Str("user", user).Str("message_id", msg.id).Msg("Ignoring message already handled")
+		return nil
+	}
+	if msg.edited {
+		// an old message could be edited into an entry at the right time, so edits never count
+		if !entry {
+			return nil
+		}
+		return b.feedback(ctx, msg, fmt.Sprintf("Sorry %s, edited messages don't count.", user), reactRejected)
+	}
+	if msg.sent.Before(b.started) {
+		if handled, err := b.backlog(ctx, l, msg, entry); handled {
+			return err
+		}
+	}
 
-	if len(cmds) > 1 {
-		switch s := cmds[1]; s {
-		case subCmdStats:
-			if err := b.getStats(ctx, &buf); err != nil {
-				return err
-			}
-			return b.send(ctx, buf.String())
-		case subCmdReload:
-			if err := b.reloadConfig(ctx, &buf); err != nil {
-				return err
-			}
-			return b.send(ctx, buf.String())
-		default:
-			buf.WriteString("Invalid subcommand(s): ")
-			buf.WriteString(strings.Join(cmds[1:], " "))
-			return b.send(ctx, buf.String())
+	if !entry {
+		args, err := splitArgs(cmd)
+		if err != nil {
+			return b.send(ctx, roomID, fmt.Sprintf("Sorry %s, I can't make sense of that: %v", user, err))
 		}
+		return b.router.route(ctx, b, mode, l, msg, args[1:])
 	}
 
-	if err := b.play(ctx, &buf, ts, user); err != nil {
+	// Entries sent before we started were received when we caught up, so the skew is how long we were away,
+	// which says nothing about the sender's server. They are taken as they are, and kept out of the averages.
+	if !msg.sent.Before(b.started) {
+		msg.trust = b.trust.check(b.log(), msg.sender, msg.sent, msg.received)
+	}
+	b.catchUpBeforePlay(ctx, l)
+	var buf strings.Builder
+	reaction, err := b.play(ctx, &buf, l, msg)
+	if err != nil {
 		return err
 	}
-	return b.send(ctx, buf.String())
+	return b.feedback(ctx, msg, buf.String(), reaction)
 }
 
-func (b *Bot) Start(ctx context.Context) error {
-	if b == nil {
-		return ErrNilReceiver
+// HandleMessage implements Handler
+func (b *Bot) HandleMessage(ctx context.Context, in Message) {
+	msg := message{
+		id:       in.ID,
+		roomID:   in.RoomID,
+		sender:   in.Sender,
+		body:     in.Body,
+		sent:     in.Sent,
+		received: in.Received,
+		ts:       ltime.GetAdjustedTime(in.Sent, in.Received),
+		edited:   in.Edited,
 	}
+	if err := b.dispatch(ctx, msg); err != nil {
+		b.log().Error().Err(err).Msg("Dispatch failed")
+	}
+}
 
-	b.log().Info().Msg("Initializing...")
-
-	// Find true address of server, in case of delegation.
-	cwk, err := mautrix.DiscoverClientAPI(ctx, b.cfg.Server)
+// HandleRedaction implements Handler.
+// Taking back an entry doesn't undo it, as that would make it possible to try again, or to get out of a miss.
+func (b *Bot) HandleRedaction(ctx context.Context, roomID, messageID string) {
+	if b.store == nil {
+		return
+	}
+	msg, found, err := b.store.Seen(ctx, messageID)
 	if err != nil {
-		return err
+		b.log().Error().Err(err).Str("message_id", messageID).Msg("Failed to look up redacted message")
+		return
+	}
+	if !found || !msg.Entry {
+		return
+	}
+	b.log().Info().Str("user", msg.Sender).Str("room_id", roomID).Str("message_id", messageID).Msg("Entry redacted")
+	if err = b.sendContent(
+		ctx,
+		roomID,
+		Content{
+			Text:     fmt.Sprintf("Sorry %s, entries can't be taken back.", msg.Sender),
+			Mentions: []string{msg.Sender},
+		},
+	); err != nil {
+		b.log().Error().Err(err).Msg("Failed to send redaction notice")
 	}
+}
 
-	b.client, err = mautrix.NewClient(cwk.Homeserver.BaseURL, "", "")
-	if err != nil {
-		return err
+// HandleJoin implements Handler
+func (b *Bot) HandleJoin(ctx context.Context, roomID string) {
+	for _, m := range b.modes {
+		b.game(ctx, m, roomID)
 	}
-	b.client.Log = b.logger
-	// adjust the bot logger now, after having passed on a clean copy to the client
-	b.logger = b.logger.With().Str("bot", b.userID).Logger()
+}
 
-	syncer := b.client.Syncer.(*mautrix.DefaultSyncer) // TODO: check cast
+// HandleLeave implements Handler
+func (b *Bot) HandleLeave(ctx context.Context, roomID string) {
+	b.removeGame(ctx, roomID)
+}
 
-	syncer.OnEventType(event.EventMessage, func(ctx context.Context, evt *event.Event) {
-		ts := ltime.GetAdjustedTime(time.UnixMilli(evt.Timestamp), time.Now())
-		// b.log().Debug().Str("room_id", evt.RoomID.String()).Msg("Message in room")
-		b.setRoom(evt.RoomID)
-		if err := b.dispatch(ctx, ts, evt.Sender.String(), evt.Content.AsMessage().Body); err != nil {
-			b.log().Error().Err(err).Msg("Dispatch failed")
-		}
-	})
-
-	syncer.OnEventType(event.StateMember, func(ctx context.Context, evt *event.Event) {
-		if evt.GetStateKey() == b.client.UserID.String() {
-			switch evt.Content.AsMember().Membership {
-			case event.MembershipInvite:
-				_, err := b.client.JoinRoomByID(ctx, evt.RoomID)
-				if err != nil {
-					b.log().Error().Err(err).
-						Str("room_id", evt.RoomID.String()).
-						Str("inviter", evt.Sender.String()).
-						Msg("Failed to join room after invite")
-				} else {
-					b.setRoom(evt.RoomID)
-					b.log().Info().
-						Str("room_id", evt.RoomID.String()).
-						Str("inviter", evt.Sender.String()).
-						Msg("Joined room after invite")
-				}
-			case event.MembershipJoin:
-				b.setRoom(evt.RoomID)
-				b.log().Info().
-					Str("room_id", evt.RoomID.String()).
-					Str("inviter", evt.Sender.String()).
-					Msg("Joined room")
-			}
-		}
-	})
+func (b *Bot) Start(ctx context.Context) error {
+	if b == nil {
+		return ErrNilReceiver
+	}
+	if b.transport == nil {
+		return ErrNoTransport
+	}
 
-	cryptoHelper, err := cryptohelper.NewCryptoHelper(b.client, []byte("1337"), b.cfg.DBPath)
-	if err != nil {
+	if err := b.checkModes(); err != nil {
 		return err
 	}
 
-	cryptoHelper.LoginAs = &mautrix.ReqLogin{
-		Type:       mautrix.AuthTypePassword,
-		Identifier: mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: b.cfg.Username},
-		Password:   b.cfg.Password,
+	b.log().Info().Msg("Initializing...")
+	b.started = b.clock.Now()
+
+	store, err := leet.OpenStore(ctx, b.cfg.DBPath)
+	if err != nil {
+		return err
 	}
+	b.store = store
 
-	if err = cryptoHelper.Init(ctx); err != nil {
+	if err = b.transport.Connect(ctx); err != nil {
 		return err
 	}
-	b.client.Crypto = cryptoHelper
+	b.logger = b.logger.With().Str("bot", b.userID).Logger()
+
+	// must be loaded before receiving starts, as that creates new games for rooms we haven't seen yet
+	b.loadGames(ctx)
 
 	go func() {
-		if err := b.client.SyncWithContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
-			b.log().Error().Err(err).Msg("SyncWithContext failed")
+		if err := b.transport.Run(ctx, b); err != nil {
+			b.log().Error().Err(err).Msg("Transport failed")
 		}
 	}()
 
-	if err = b.leet.LoadConfigFile(); err != nil {
-		b.log().Error().Err(err).Msg("Failed to load config file!")
-	}
-
-	if err = b.scheduleConfigSave(); err != nil {
-		b.log().Error().Err(err).Msg("Failed to schedule saving of config!")
+	if err = b.scheduleRound(ctx); err != nil {
+		b.log().Error().Err(err).Msg("Failed to schedule round!")
 	}
 
 	if b.cron != nil {
@@ -315,14 +772,19 @@ func (b *Bot) Start(ctx context.Context) error {
 		b.cron.Stop()
 	}
 
-	b.log().Debug().Msg("Closing Crypto Helper...")
-	if err = cryptoHelper.Close(); err != nil {
-		b.log().Error().Err(err).Msg("Failed to close cryptoHelper")
+	b.log().Debug().Msg("Closing transport...")
+	if err = b.transport.Close(); err != nil {
+		b.log().Error().Err(err).Msg("Failed to close transport")
 	}
 
-	b.log().Debug().Msg("Saving config to file...")
-	if err = b.leet.SaveConfigFile(); err != nil {
-		b.log().Error().Err(err).Msg("Failed to save config, all changes in this session are now lost!")
+	// ctx is already cancelled here, so we need a fresh one for the final save
+	b.log().Debug().Msg("Saving games...")
+	b.saveGames(context.Background())
+	b.closeGames()
+
+	b.log().Debug().Msg("Closing database...")
+	if err = b.store.Close(); err != nil {
+		b.log().Error().Err(err).Msg("Failed to close database")
 	}
 
 	b.log().Info().Msg("Done!")
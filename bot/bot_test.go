@@ -1,9 +1,17 @@
 package bot
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/oddlid/leetbot_matrix/leet"
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // func Test_Bot_getOwnUserID(t *testing.T) {
@@ -40,3 +48,90 @@ func Test_Bot_fromSelf(t *testing.T) {
 	assert.False(t, b.fromSelf(user))
 	assert.True(t, b.fromSelf(b.userID))
 }
+
+func Test_Bot_history(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b := Bot{}
+	l := leet.New(zerolog.Nop(), leet.Config{})
+
+	var buf strings.Builder
+	assert.NoError(t, b.history(ctx, &buf, l, "!1337", "@user:test.com", nil))
+	assert.Equal(t, "History is not available", buf.String())
+
+	for _, args := range [][]string{{"0"}, {"@user:test.com", "x"}, {"@user:test.com", "-1"}} {
+		buf.Reset()
+		assert.NoError(t, b.history(ctx, &buf, l, "!1337", "@user:test.com", args))
+		assert.Contains(t, buf.String(), "Usage: !1337 history")
+	}
+}
+
+func Test_Bot_reloadConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := leet.OpenStore(ctx, filepath.Join(dir, "leet.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"room": "!old:test.com"}`), 0o600))
+	cfg := leet.Config{Store: store, Key: "game", ConfigFile: path}
+	l := leet.New(zerolog.Nop(), cfg)
+	t.Cleanup(l.Close)
+	require.NoError(t, l.Load(ctx))
+
+	// the game is in the database now, but reloading should still read the file
+	require.NoError(t, os.WriteFile(path, []byte(`{"room": "!new:test.com"}`), 0o600))
+	var buf strings.Builder
+	b := Bot{}
+	require.NoError(t, b.reloadConfig(ctx, &buf, l))
+	assert.Equal(t, "Config reloaded successfully.", buf.String())
+	room, err := l.GetRoom()
+	require.NoError(t, err)
+	assert.Equal(t, "!new:test.com", room)
+
+	// and what was reloaded is what's stored
+	l = leet.New(zerolog.Nop(), cfg)
+	t.Cleanup(l.Close)
+	require.NoError(t, l.Load(ctx))
+	room, err = l.GetRoom()
+	require.NoError(t, err)
+	assert.Equal(t, "!new:test.com", room)
+}
+
+func Test_Bot_checkModes(t *testing.T) {
+	t.Parallel()
+
+	tf := ltime.TimeFrame{Hour: 13, Minute: 37}
+	evening := ltime.TimeFrame{Hour: 22, Minute: 22}
+	tests := []struct {
+		name  string
+		games []GameConfig
+		err   string
+	}{
+		{name: "main only"},
+		{name: "named", games: []GameConfig{{Name: "evening", TimeFrame: evening}}},
+		{name: "no name", games: []GameConfig{{TimeFrame: evening}}, err: "invalid game name"},
+		{name: "bad name", games: []GameConfig{{Name: "eve ning", TimeFrame: evening}}, err: "invalid game name"},
+		{
+			name:  "same name",
+			games: []GameConfig{{Name: "evening", TimeFrame: evening}, {Name: "evening", TimeFrame: ltime.TimeFrame{Hour: 4, Minute: 20}}},
+			err:   "more than one game named",
+		},
+		{name: "same command", games: []GameConfig{{Name: "again", TimeFrame: tf}}, err: `more than one game with the command "!1337"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := New(BotConfig{TimeFrame: tf, Games: tt.games}, nil, zerolog.Nop()).checkModes()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/oddlid/leetbot_matrix/leet"
+)
+
+// historyArgs is how to give the arguments to the history subcommand
+var historyArgs = fmt.Sprintf("[user] [rounds (1-%d)]", leet.MaxHistory)
+
+// call is a subcommand given in a message, with what's needed to run it
+type call struct {
+	mode *gameMode
+	game *leet.Leet
+	msg  message
+	args []string // after the subcommand
+}
+
+// subcommand is something to do besides playing, given after the command of the game, like "!1337 stats"
+type subcommand struct {
+	name    string
+	args    string // how to give the arguments, for the usage, like "[user]"
+	help    string
+	minArgs int
+	maxArgs int
+	run     func(b *Bot, ctx context.Context, c call) error
+}
+
+// usage returns how to use the subcommand with the given command
+func (sc subcommand) usage(command string) string {
+	if sc.args == "" {
+		return fmt.Sprintf("Usage: %s %s", command, sc.name)
+	}
+	return fmt.Sprintf("Usage: %s %s %s", command, sc.name, sc.args)
+}
+
+// router finds the subcommand given in a message, checks its arguments, and runs it
+type router struct {
+	subcommands []subcommand // in the order they are shown in the help
+}
+
+// register adds a subcommand, which is shown in the help in the order registered
+func (r *router) register(sc subcommand) {
+	r.subcommands = append(r.subcommands, sc)
+}
+
+func (r *router) find(name string) (subcommand, bool) {
+	for _, sc := range r.subcommands {
+		if sc.name == name {
+			return sc, true
+		}
+	}
+	return subcommand{}, false
+}
+
+// route runs the subcommand given in args, which is the message split by splitArgs, without the command.
+// Unknown subcommands and wrong arguments are answered with how to do it right.
+func (r *router) route(ctx context.Context, b *Bot, mode *gameMode, l *leet.Leet, msg message, args []string) error {
+	sc, ok := r.find(args[0])
+	if !ok {
+		return b.send(ctx, msg.roomID, fmt.Sprintf("Unknown subcommand %q, try %s %s", args[0], mode.command, subCmdHelp))
+	}
+	args = args[1:]
+	if len(args) < sc.minArgs || len(args) > sc.maxArgs {
+		return b.send(ctx, msg.roomID, sc.usage(mode.command))
+	}
+	return sc.run(b, ctx, call{mode: mode, game: l, msg: msg, args: args})
+}
+
+// help writes what can be done with the command of the mode, and which other games there are
+func (r *router) help(mode *gameMode, others []*gameMode) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s - play, by sending just that at %02d:%02d\n", mode.command, mode.tf.Hour, mode.tf.Minute)
+	for _, sc := range r.subcommands {
+		fmt.Fprintf(&sb, "%s %s", mode.command, sc.name)
+		if sc.args != "" {
+			fmt.Fprintf(&sb, " %s", sc.args)
+		}
+		fmt.Fprintf(&sb, " - %s\n", sc.help)
+	}
+	if len(others) > 0 {
+		commands := make([]string, len(others))
+		for i, m := range others {
+			commands[i] = m.command
+		}
+		fmt.Fprintf(&sb, "Other games: %s\n", strings.Join(commands, ", "))
+	}
+	return sb.String()
+}
+
+// newRouter returns a router with the subcommands of the bot
+func newRouter() *router {
+	r := &router{}
+	r.register(
+		subcommand{
+			name: subCmdStats,
+			help: "show the standings",
+			run: func(b *Bot, ctx context.Context, c call) error {
+				return b.getStats(ctx, c.msg.roomID, c.game)
+			},
+		},
+	)
+	r.register(
+		subcommand{
+			name:    subCmdHistory,
+			args:    historyArgs,
+			help:    "show how the last rounds went for a player, you if not given",
+			maxArgs: 2,
+			run: func(b *Bot, ctx context.Context, c call) error {
+				var buf strings.Builder
+				if err := b.history(ctx, &buf, c.game, c.mode.command, c.msg.sender, c.args); err != nil {
+					return err
+				}
+				return b.send(ctx, c.msg.roomID, buf.String())
+			},
+		},
+	)
+	r.register(
+		subcommand{
+			name: subCmdReload,
+			help: "load the game from the config file again, between rounds",
+			run: func(b *Bot, ctx context.Context, c call) error {
+				var buf strings.Builder
+				if err := b.reloadConfig(ctx, &buf, c.game); err != nil {
+					return err
+				}
+				return b.send(ctx, c.msg.roomID, buf.String())
+			},
+		},
+	)
+	r.register(
+		subcommand{
+			name: subCmdHelp,
+			help: "show this help",
+			run: func(b *Bot, ctx context.Context, c call) error {
+				var others []*gameMode
+				for _, m := range b.modes {
+					if m != c.mode {
+						others = append(others, m)
+					}
+				}
+				return b.send(ctx, c.msg.roomID, b.router.help(c.mode, others))
+			},
+		},
+	)
+	return r
+}
+
+// splitArgs splits a message into arguments, separated by white space. An argument starting with " or ' goes on
+// to the matching quote, so that it can contain white space, while quotes inside an argument are kept as is.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args  []string
+		arg   strings.Builder
+		inArg bool
+		quote rune
+	)
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case !inArg && (r == '"' || r == '\''):
+			quote = r
+			inArg = true
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing %c", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
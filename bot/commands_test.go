@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitArgs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{in: "", want: nil},
+		{in: "!1337", want: []string{"!1337"}},
+		{in: "  !1337   stats \t", want: []string{"!1337", "stats"}},
+		{in: `!1337 history "Alice Smith" 3`, want: []string{"!1337", "history", "Alice Smith", "3"}},
+		{in: `!1337 history 'Alice "The Ace" Smith'`, want: []string{"!1337", "history", `Alice "The Ace" Smith`}},
+		{in: `!1337 history O'Brien`, want: []string{"!1337", "history", "O'Brien"}},
+		{in: `!1337 history ""`, want: []string{"!1337", "history", ""}},
+		{in: `!1337 history "Alice`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.in)
+		if tt.err {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func Test_router_help(t *testing.T) {
+	t.Parallel()
+
+	mode := newGameMode("", ltime.TimeFrame{Hour: 13, Minute: 37})
+	others := []*gameMode{newGameMode("night", ltime.TimeFrame{Hour: 4, Minute: 20})}
+	help := newRouter().help(mode, others)
+
+	assert.True(t, strings.HasPrefix(help, "!1337 - play, by sending just that at 13:37\n"), help)
+	assert.Contains(t, help, "!1337 stats - show the standings\n")
+	assert.Contains(t, help, "!1337 history "+historyArgs+" - ")
+	assert.Contains(t, help, "!1337 help - show this help\n")
+	assert.True(t, strings.HasSuffix(help, "Other games: !0420\n"), help)
+	assert.NotContains(t, newRouter().help(mode, nil), "Other games")
+}
+
+func Test_Bot_dispatch_commands(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var buf strings.Builder
+	ct := NewConsoleTransport(nil, &buf, "bob", nil)
+	tf := ltime.TimeFrame{Hour: 13, Minute: 37}
+	b := New(
+		BotConfig{TimeFrame: tf, Games: []GameConfig{{Name: "night", TimeFrame: ltime.TimeFrame{Hour: 4, Minute: 20}}}},
+		ct,
+		zerolog.Nop(),
+	)
+	b.HandleJoin(ctx, consoleRoom)
+
+	tests := []struct {
+		body string
+		want string // start of the reply, or empty for none
+	}{
+		{body: "!13370", want: ""},
+		{body: "!1337stats", want: ""},
+		{body: "hello !1337 stats", want: ""},
+		{body: "!1337   stats", want: "[console] Stats since "},
+		{body: "!1337 stats now", want: "[console] Usage: !1337 stats\n"},
+		{body: "!1337 history a b c", want: "[console] Usage: !1337 history " + historyArgs + "\n"},
+		{body: `!1337 history "Alice Smith" 3`, want: "[console] History is not available\n"},
+		{body: `!1337 history "Alice`, want: "[console] Sorry bob, I can't make sense of that: missing closing \"\n"},
+		{body: "!1337 nope", want: "[console] Unknown subcommand \"nope\", try !1337 help\n"},
+		{body: "!0420 help", want: "[console] !0420 - play, by sending just that at 04:20\n"},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		b.HandleMessage(ctx, Message{RoomID: consoleRoom, Sender: "bob", Body: tt.body})
+		if tt.want == "" {
+			assert.Empty(t, buf.String(), tt.body)
+		} else {
+			assert.True(t, strings.HasPrefix(buf.String(), tt.want), "%q: %q", tt.body, buf.String())
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/oddlid/leetbot_matrix/util"
+)
+
+const (
+	consoleRoom   = `console`
+	consoleUserID = `leetbot`
+)
+
+// ConsoleTransport plays the game on stdin/stdout, or any other reader and writer, without a server.
+// Each line read is a message from the player, or from someone else if the line starts with "<name> ".
+type ConsoleTransport struct {
+	r      io.Reader
+	w      io.Writer
+	player string
+	clock  ltime.Clock
+	nextID int
+	mu     sync.Mutex // guards w and nextID
+}
+
+// NewConsoleTransport returns a transport for the given player, where lines are read from r, and messages
+// written to w. The clock tells when lines are read, if nil, the system clock is used.
+func NewConsoleTransport(r io.Reader, w io.Writer, player string, clock ltime.Clock) *ConsoleTransport {
+	return &ConsoleTransport{
+		r:      r,
+		w:      w,
+		player: player,
+		clock:  ltime.ClockOrReal(clock),
+	}
+}
+
+func (ct *ConsoleTransport) UserID() string {
+	return consoleUserID
+}
+
+func (ct *ConsoleTransport) Connect(_ context.Context) error {
+	return nil
+}
+
+func (ct *ConsoleTransport) Close() error {
+	return nil
+}
+
+// parseLine returns who the line is from, and what they said
+func (ct *ConsoleTransport) parseLine(line string) (string, string) {
+	if strings.HasPrefix(line, "<") {
+		if name, body, found := strings.Cut(line[1:], "> "); found && name != "" {
+			return name, body
+		}
+	}
+	return ct.player, line
+}
+
+// Run reads messages until ctx is done or there is nothing more to read
+func (ct *ConsoleTransport) Run(ctx context.Context, h Handler) error {
+	h.HandleJoin(ctx, consoleRoom)
+
+	lines := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(ct.r)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		errc <- scanner.Err()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case line, ok := <-lines:
+			if !ok {
+				return <-errc
+			}
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			now := ct.clock.Now()
+			sender, body := ct.parseLine(line)
+			h.HandleMessage(
+				ctx,
+				Message{
+					RoomID:   consoleRoom,
+					Sender:   sender,
+					Body:     body,
+					Sent:     now,
+					Received: now,
+				},
+			)
+		}
+	}
+}
+
+func (ct *ConsoleTransport) write(roomID, prefix string, content Content) error {
+	if roomID == "" {
+		return ErrNoRoomID
+	}
+	return util.Fpf(ct.w, "[%s] %s%s\n", roomID, prefix, content.Text)
+}
+
+func (ct *ConsoleTransport) Send(_ context.Context, roomID string, content Content) (string, error) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if err := ct.write(roomID, "", content); err != nil {
+		return "", err
+	}
+	ct.nextID++
+	return strconv.Itoa(ct.nextID), nil
+}
+
+// Edit can't change what's already printed, so it prints the new version, marked as such
+func (ct *ConsoleTransport) Edit(_ context.Context, roomID, messageID string, content Content) error {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.write(roomID, "(edit of #"+messageID+") ", content)
+}
+
+// React is not supported, as messages on the console have no IDs
+func (ct *ConsoleTransport) React(_ context.Context, _, _, _ string) error {
+	return errors.ErrUnsupported
+}
+
+// DisplayName is the name given on the console, which is also the user ID
+func (ct *ConsoleTransport) DisplayName(_ context.Context, _, userID string) string {
+	return userID
+}
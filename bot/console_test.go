@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingHandler struct {
+	msgs     []Message
+	joined   []string
+	left     []string
+	redacted []string
+}
+
+func (rh *recordingHandler) HandleMessage(_ context.Context, msg Message) {
+	rh.msgs = append(rh.msgs, msg)
+}
+
+func (rh *recordingHandler) HandleJoin(_ context.Context, roomID string) {
+	rh.joined = append(rh.joined, roomID)
+}
+
+func (rh *recordingHandler) HandleLeave(_ context.Context, roomID string) {
+	rh.left = append(rh.left, roomID)
+}
+
+func (rh *recordingHandler) HandleRedaction(_ context.Context, _, messageID string) {
+	rh.redacted = append(rh.redacted, messageID)
+}
+
+func (rh *recordingHandler) HandleCaughtUp(_ context.Context) {}
+
+func Test_ConsoleTransport_Run(t *testing.T) {
+	t.Parallel()
+
+	clock := ltime.NewFakeClock(time.Date(2025, 5, 12, 13, 37, 0, 0, time.UTC))
+	ct := NewConsoleTransport(strings.NewReader("!1337\n\n<alice> !1337 stats\n<> hi\n"), nil, "bob", clock)
+	var h recordingHandler
+	require.NoError(t, ct.Run(context.Background(), &h))
+
+	assert.Equal(t, []string{consoleRoom}, h.joined)
+	require.Len(t, h.msgs, 3)
+	assert.Equal(t, "bob", h.msgs[0].Sender)
+	assert.Equal(t, "!1337", h.msgs[0].Body)
+	assert.Equal(t, consoleRoom, h.msgs[0].RoomID)
+	assert.Equal(t, clock.Now(), h.msgs[0].Sent)
+	assert.Equal(t, clock.Now(), h.msgs[0].Received)
+	assert.Equal(t, "alice", h.msgs[1].Sender)
+	assert.Equal(t, "!1337 stats", h.msgs[1].Body)
+	assert.Equal(t, "bob", h.msgs[2].Sender)
+	assert.Equal(t, "<> hi", h.msgs[2].Body)
+}
+
+func Test_ConsoleTransport_Send(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var buf strings.Builder
+	ct := NewConsoleTransport(nil, &buf, "bob", nil)
+
+	msgID, err := ct.Send(ctx, consoleRoom, Content{Text: "hello"})
+	require.NoError(t, err)
+	require.NoError(t, ct.Edit(ctx, consoleRoom, msgID, Content{Text: "bye"}))
+	_, err = ct.Send(ctx, "", Content{Text: "hello"})
+	assert.ErrorIs(t, err, ErrNoRoomID)
+
+	assert.Equal(t, "[console] hello\n[console] (edit of #1) bye\n", buf.String())
+}
+
+func Test_Bot_HandleMessage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var buf strings.Builder
+	ct := NewConsoleTransport(nil, &buf, "bob", nil)
+	b := New(BotConfig{}, ct, zerolog.Nop())
+	b.HandleJoin(ctx, consoleRoom)
+	assert.Len(t, b.gameRooms(), 1)
+
+	b.HandleMessage(ctx, Message{RoomID: consoleRoom, Sender: "bob", Body: "!0000 nope"})
+	assert.Equal(t, "[console] Unknown subcommand \"nope\", try !0000 help\n", buf.String())
+
+	// messages from ourselves are ignored
+	buf.Reset()
+	b.HandleMessage(ctx, Message{RoomID: consoleRoom, Sender: ct.UserID(), Body: "!0000 nope"})
+	assert.Empty(t, buf.String())
+
+	b.HandleLeave(ctx, consoleRoom)
+	assert.Empty(t, b.gameRooms())
+}
+
+func Test_MultiTransport(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var out1, out2 strings.Builder
+	t1 := NewConsoleTransport(strings.NewReader("hello\n"), &out1, "one", nil)
+	t2 := NewConsoleTransport(strings.NewReader(""), &out2, "two", nil)
+	mt := NewMultiTransport(t1, t2)
+	assert.Equal(t, t1.UserID(), mt.UserID())
+
+	_, err := mt.Send(ctx, consoleRoom, Content{Text: "nobody here yet"})
+	assert.ErrorIs(t, err, ErrNoGame)
+	_, err = mt.Send(ctx, "", Content{Text: "nobody here yet"})
+	assert.ErrorIs(t, err, ErrNoRoomID)
+
+	h := syncHandler{events: make(chan struct{}, 10)}
+	require.NoError(t, mt.Run(ctx, &h))
+	require.Len(t, h.msgs, 1)
+	assert.Equal(t, "one", h.msgs[0].Sender)
+
+	// both console transports use the same room, so make sure it's routed to the second one
+	rh := routingHandler{mt: mt, t: t2, h: &h}
+	rh.HandleJoin(ctx, consoleRoom)
+	_, err = mt.Send(ctx, consoleRoom, Content{Text: "hi"})
+	require.NoError(t, err)
+	require.NoError(t, mt.Edit(ctx, consoleRoom, "1", Content{Text: "hi again"}))
+	assert.Empty(t, out1.String())
+	assert.Equal(t, "[console] hi\n[console] (edit of #1) hi again\n", out2.String())
+
+	// our own messages are not passed on
+	rh.HandleMessage(ctx, Message{RoomID: consoleRoom, Sender: t2.UserID()})
+	assert.Len(t, h.msgs, 1)
+
+	rh.HandleLeave(ctx, consoleRoom)
+	_, err = mt.Send(ctx, consoleRoom, Content{Text: "gone"})
+	assert.ErrorIs(t, err, ErrNoGame)
+	assert.NoError(t, mt.Close())
+}
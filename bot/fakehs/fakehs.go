@@ -0,0 +1,508 @@
+// Package fakehs is an in-process Matrix homeserver for tests, serving just enough of the client-server API
+// for the bot to log in, set up encryption, sync, join rooms and send messages.
+// Tests inject events with any origin_server_ts they like, and check what the bot sent back.
+package fakehs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSyncWait caps how long a sync request waits for new events, to keep tests fast
+const maxSyncWait = 2 * time.Second
+
+// Event is a room event, as seen in sync responses
+type Event struct {
+	Type           string         `json:"type"`
+	EventID        string         `json:"event_id"`
+	Sender         string         `json:"sender"`
+	OriginServerTS int64          `json:"origin_server_ts"`
+	StateKey       *string        `json:"state_key,omitempty"`
+	Content        map[string]any `json:"content"`
+	RoomID         string         `json:"-"`
+	invite         bool           // goes in the invite section of sync, instead of the timeline
+}
+
+type user struct {
+	password    string
+	token       string
+	deviceID    string
+	deviceKeys  json.RawMessage // as uploaded, nil until then
+	oneTimeKeys int             // how many have been uploaded, as they are never claimed
+}
+
+// Server is the fake homeserver. All methods are safe for concurrent use.
+type Server struct {
+	*httptest.Server
+	name         string
+	users        map[string]*user // by user ID
+	events       []Event          // everything that happened, in order, the index is the sync token
+	sent         []Event          // events sent by clients
+	joined       map[string]map[string]bool
+	nextID       int
+	changed      chan struct{} // closed and replaced whenever events are added
+	unrecognized []string      // requests for endpoints that are not implemented
+	syncTokens   []string      // the since token of each sync, in order
+	mu           sync.Mutex
+}
+
+// New starts a server, which must be closed when done
+func New() *Server {
+	s := &Server{
+		users:   make(map[string]*user),
+		joined:  make(map[string]map[string]bool),
+		changed: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/matrix/client", s.wellKnown)
+	mux.HandleFunc("GET /_matrix/client/versions", s.versions)
+	mux.HandleFunc("POST /_matrix/client/v3/login", s.login)
+	mux.HandleFunc("POST /_matrix/client/v3/user/{userID}/filter", s.filter)
+	mux.HandleFunc("GET /_matrix/client/v3/sync", s.sync)
+	mux.HandleFunc("POST /_matrix/client/v3/rooms/{roomID}/join", s.join)
+	mux.HandleFunc("POST /_matrix/client/v3/join/{roomID}", s.join)
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{roomID}/send/{eventType}/{txnID}", s.send)
+	mux.HandleFunc("POST /_matrix/client/v3/keys/upload", s.keysUpload)
+	mux.HandleFunc("POST /_matrix/client/v3/keys/query", s.keysQuery)
+	mux.HandleFunc("/", s.unknown)
+	s.Server = httptest.NewServer(mux)
+	u, _ := url.Parse(s.URL)
+	s.name = u.Host
+	return s
+}
+
+// Name returns the server name, which is the part after the colon in user and room IDs
+func (s *Server) Name() string {
+	return s.name
+}
+
+// UserID returns the full user ID for the given local part
+func (s *Server) UserID(localpart string) string {
+	return fmt.Sprintf("@%s:%s", localpart, s.name)
+}
+
+// AddUser registers a user that can log in with the given password, or changes the password of an existing one,
+// and returns the user ID
+func (s *Server) AddUser(localpart, password string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userID := s.UserID(localpart)
+	if u, ok := s.users[userID]; ok {
+		// keep the keys, as when logging in again after a restart
+		u.password = password
+		return userID
+	}
+	s.users[userID] = &user{password: password}
+	return userID
+}
+
+// addEvent must be called with the lock held
+func (s *Server) addEvent(evt Event) Event {
+	s.nextID++
+	if evt.EventID == "" {
+		evt.EventID = fmt.Sprintf("$%d:%s", s.nextID, s.name)
+	}
+	if evt.OriginServerTS == 0 {
+		evt.OriginServerTS = time.Now().UnixMilli()
+	}
+	s.events = append(s.events, evt)
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return evt
+}
+
+func stateKey(key string) *string {
+	return &key
+}
+
+// Invite invites the user to the room, which is created if needed
+func (s *Server) Invite(roomID, sender, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addEvent(Event{
+		Type:     "m.room.member",
+		Sender:   sender,
+		StateKey: stateKey(userID),
+		Content:  map[string]any{"membership": "invite"},
+		RoomID:   roomID,
+		invite:   true,
+	})
+}
+
+func (s *Server) setMembership(roomID, userID, membership string) {
+	s.setMember(roomID, userID, map[string]any{"membership": membership})
+}
+
+func (s *Server) setMember(roomID, userID string, content map[string]any) {
+	if s.joined[roomID] == nil {
+		s.joined[roomID] = make(map[string]bool)
+	}
+	s.joined[roomID][userID] = content["membership"] == "join"
+	s.addEvent(Event{
+		Type:     "m.room.member",
+		Sender:   userID,
+		StateKey: stateKey(userID),
+		Content:  content,
+		RoomID:   roomID,
+	})
+}
+
+// Join makes the user join the room directly, without an invite
+func (s *Server) Join(roomID, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setMembership(roomID, userID, "join")
+}
+
+// SetDisplayName makes the user join the room, if not already there, with the given display name
+func (s *Server) SetDisplayName(roomID, userID, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setMember(roomID, userID, map[string]any{"membership": "join", "displayname": name})
+}
+
+// Leave makes the user leave the room
+func (s *Server) Leave(roomID, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setMembership(roomID, userID, "leave")
+}
+
+// SendText injects a text message from sender, with the given origin_server_ts, and returns its event ID
+func (s *Server) SendText(roomID, sender, body string, ts time.Time) string {
+	return s.SendEvent(Event{
+		Type:           "m.room.message",
+		Sender:         sender,
+		OriginServerTS: ts.UnixMilli(),
+		Content:        map[string]any{"msgtype": "m.text", "body": body},
+		RoomID:         roomID,
+	})
+}
+
+// SendEvent injects any room event, and returns its event ID
+func (s *Server) SendEvent(evt Event) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addEvent(evt).EventID
+}
+
+// Sent returns the events sent by clients so far
+func (s *Server) Sent() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.sent...)
+}
+
+// Unrecognized returns the requests that were answered with M_UNRECOGNIZED, since the endpoint is not implemented
+func (s *Server) Unrecognized() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.unrecognized...)
+}
+
+// SyncTokens returns the since token that each sync so far was made with, which is empty for an initial sync
+func (s *Server) SyncTokens() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.syncTokens...)
+}
+
+// WaitForSent waits until a client has sent at least n events, and returns them all.
+// It returns what was sent so far, and an error, if it takes longer than timeout.
+func (s *Server) WaitForSent(n int, timeout time.Duration) ([]Event, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		sent := append([]Event(nil), s.sent...)
+		changed := s.changed
+		s.mu.Unlock()
+		if len(sent) >= n {
+			return sent, nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return sent, fmt.Errorf("timed out waiting for %d sent events, got %d", n, len(sent))
+		}
+	}
+}
+
+// WaitForJoin waits until the user has joined the room
+func (s *Server) WaitForJoin(roomID, userID string, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		joined := s.joined[roomID][userID]
+		changed := s.changed
+		s.mu.Unlock()
+		if joined {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for %s to join %s", userID, roomID)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, map[string]string{"errcode": code, "error": msg})
+}
+
+// authUser returns the user ID for the access token in the request, if valid
+func (s *Server) authUser(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, u := range s.users {
+		if u.token == token {
+			return userID, true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) requireAuth(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "M_UNKNOWN_TOKEN", "Unknown access token")
+	}
+	return userID, ok
+}
+
+func (s *Server) wellKnown(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"m.homeserver": map[string]string{"base_url": s.URL}})
+}
+
+func (s *Server) versions(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"versions": []string{"v1.11"}})
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Identifier struct {
+			User string `json:"user"`
+		} `json:"identifier"`
+		Password string `json:"password"`
+		DeviceID string `json:"device_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "M_NOT_JSON", err.Error())
+		return
+	}
+	userID := req.Identifier.User
+	if !strings.HasPrefix(userID, "@") {
+		userID = s.UserID(userID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok || u.password != req.Password {
+		writeError(w, http.StatusForbidden, "M_FORBIDDEN", "Invalid username or password")
+		return
+	}
+	s.nextID++
+	u.token = "token" + strconv.Itoa(s.nextID)
+	deviceID := req.DeviceID
+	if deviceID == "" {
+		deviceID = "DEVICE" + strconv.Itoa(s.nextID)
+	}
+	u.deviceID = deviceID
+	writeJSON(w, http.StatusOK, map[string]string{"user_id": userID, "access_token": u.token, "device_id": deviceID})
+}
+
+func (s *Server) filter(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAuth(w, r); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"filter_id": "1"})
+}
+
+type syncEvents struct {
+	Events []Event `json:"events"`
+}
+
+type joinedRoom struct {
+	Timeline syncEvents `json:"timeline"`
+	State    syncEvents `json:"state"`
+}
+
+type invitedRoom struct {
+	InviteState syncEvents `json:"invite_state"`
+}
+
+// sync returns all events since the given token, or waits a while for new ones, if there are none yet.
+// Users only see events in rooms they have joined, and their own invites.
+func (s *Server) sync(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.syncTokens = append(s.syncTokens, r.URL.Query().Get("since"))
+	oneTimeKeys := s.users[userID].oneTimeKeys
+	s.mu.Unlock()
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+	wait := min(time.Duration(timeout)*time.Millisecond, maxSyncWait)
+
+	for {
+		s.mu.Lock()
+		events := s.events[min(since, len(s.events)):]
+		next := len(s.events)
+		changed := s.changed
+		joined := make(map[string]bool, len(s.joined))
+		for roomID, members := range s.joined {
+			joined[roomID] = members[userID]
+		}
+		s.mu.Unlock()
+
+		join := make(map[string]*joinedRoom)
+		invite := make(map[string]*invitedRoom)
+		for _, evt := range events {
+			switch {
+			case evt.invite && evt.StateKey != nil && *evt.StateKey == userID:
+				if invite[evt.RoomID] == nil {
+					invite[evt.RoomID] = &invitedRoom{}
+				}
+				invite[evt.RoomID].InviteState.Events = append(invite[evt.RoomID].InviteState.Events, evt)
+			case !evt.invite && (joined[evt.RoomID] || (evt.StateKey != nil && *evt.StateKey == userID)):
+				if join[evt.RoomID] == nil {
+					join[evt.RoomID] = &joinedRoom{}
+				}
+				join[evt.RoomID].Timeline.Events = append(join[evt.RoomID].Timeline.Events, evt)
+			}
+		}
+
+		if len(join) > 0 || len(invite) > 0 || wait <= 0 {
+			writeJSON(w, http.StatusOK, map[string]any{
+				"next_batch":                 strconv.Itoa(next),
+				"rooms":                      map[string]any{"join": join, "invite": invite},
+				"device_one_time_keys_count": map[string]int{"signed_curve25519": oneTimeKeys},
+			})
+			return
+		}
+
+		since = next
+		select {
+		case <-changed:
+		case <-time.After(wait):
+			wait = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) join(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	roomID := r.PathValue("roomID")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setMembership(roomID, userID, "join")
+	writeJSON(w, http.StatusOK, map[string]string{"room_id": roomID})
+}
+
+func (s *Server) send(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	var content map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+		writeError(w, http.StatusBadRequest, "M_NOT_JSON", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roomID := r.PathValue("roomID")
+	if !s.joined[roomID][userID] {
+		writeError(w, http.StatusForbidden, "M_FORBIDDEN", "Not in room")
+		return
+	}
+	evt := s.addEvent(Event{
+		Type:    r.PathValue("eventType"),
+		Sender:  userID,
+		Content: content,
+		RoomID:  roomID,
+	})
+	s.sent = append(s.sent, evt)
+	writeJSON(w, http.StatusOK, map[string]string{"event_id": evt.EventID})
+}
+
+func (s *Server) keysUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		DeviceKeys  json.RawMessage            `json:"device_keys"`
+		OneTimeKeys map[string]json.RawMessage `json:"one_time_keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "M_NOT_JSON", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.users[userID]
+	if len(req.DeviceKeys) > 0 && string(req.DeviceKeys) != "null" {
+		u.deviceKeys = req.DeviceKeys
+	}
+	u.oneTimeKeys += len(req.OneTimeKeys)
+	writeJSON(w, http.StatusOK, map[string]any{"one_time_key_counts": map[string]int{"signed_curve25519": u.oneTimeKeys}})
+}
+
+// keysQuery returns the device keys uploaded by the users asked for, whatever devices are asked for
+func (s *Server) keysQuery(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAuth(w, r); !ok {
+		return
+	}
+	var req struct {
+		DeviceKeys map[string]json.RawMessage `json:"device_keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "M_NOT_JSON", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deviceKeys := make(map[string]map[string]json.RawMessage)
+	for userID := range req.DeviceKeys {
+		if u, ok := s.users[userID]; ok && u.deviceKeys != nil {
+			deviceKeys[userID] = map[string]json.RawMessage{u.deviceID: u.deviceKeys}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"device_keys": deviceKeys})
+}
+
+func (s *Server) unknown(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.unrecognized = append(s.unrecognized, r.Method+" "+r.URL.Path)
+	s.mu.Unlock()
+	writeError(w, http.StatusNotFound, "M_UNRECOGNIZED", "Unrecognized request")
+}
@@ -0,0 +1,73 @@
+package fakehs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func Test_Server(t *testing.T) {
+	t.Parallel()
+
+	const roomID = `!room:fake`
+
+	ctx := context.Background()
+	hs := New()
+	defer hs.Close()
+	userID := hs.AddUser("bot", "secret")
+
+	client, err := mautrix.NewClient(hs.URL, "", "")
+	require.NoError(t, err)
+	_, err = client.Login(ctx, &mautrix.ReqLogin{
+		Type:       mautrix.AuthTypePassword,
+		Identifier: mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: "bot"},
+		Password:   "wrong",
+	})
+	require.ErrorIs(t, err, mautrix.MForbidden)
+	_, err = client.Login(ctx, &mautrix.ReqLogin{
+		Type:             mautrix.AuthTypePassword,
+		Identifier:       mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: "bot"},
+		Password:         "secret",
+		StoreCredentials: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, id.UserID(userID), client.UserID)
+
+	// messages in rooms we're not in are not seen
+	ts := time.Date(2025, 1, 2, 13, 37, 0, 0, time.UTC)
+	hs.SendText(roomID, hs.UserID("alice"), "too early", ts)
+	hs.Invite(roomID, hs.UserID("alice"), userID)
+	resp, err := client.SyncRequest(ctx, 0, "", "", false, event.PresenceOnline)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Rooms.Join)
+	require.Contains(t, resp.Rooms.Invite, id.RoomID(roomID))
+
+	_, err = client.JoinRoomByID(ctx, roomID)
+	require.NoError(t, err)
+	require.NoError(t, hs.WaitForJoin(roomID, userID, time.Second))
+	hs.SendText(roomID, hs.UserID("alice"), "!1337", ts)
+	since := resp.NextBatch
+	resp, err = client.SyncRequest(ctx, 0, since, "", false, event.PresenceOnline)
+	require.NoError(t, err)
+	timeline := resp.Rooms.Join[id.RoomID(roomID)].Timeline.Events
+	require.Len(t, timeline, 2)
+	assert.Equal(t, event.StateMember, timeline[0].Type)
+	assert.Equal(t, ts.UnixMilli(), timeline[1].Timestamp)
+	assert.Equal(t, []string{"", since}, hs.SyncTokens())
+
+	_, err = client.SendText(ctx, roomID, "hello")
+	require.NoError(t, err)
+	sent := hs.Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "hello", sent[0].Content["body"])
+
+	_, err = client.GetProfile(ctx, id.UserID(userID))
+	require.ErrorIs(t, err, mautrix.MUnrecognized)
+	assert.Equal(t, []string{"GET /_matrix/client/v3/profile/" + userID}, hs.Unrecognized())
+}
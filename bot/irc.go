@@ -0,0 +1,463 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+)
+
+const (
+	ircMaxBackoff   = 5 * time.Minute
+	ircMinBackoff   = time.Second
+	ircStableAfter  = time.Minute // a connection lasting this long resets the backoff
+	ircSendInterval = 500 * time.Millisecond
+	ircMaxLineBytes = 400 // leaves room for the prefix the server adds when relaying
+	ircServerTime   = `server-time`
+	ircSASL         = `sasl`
+)
+
+var (
+	ErrIRCRegistration = errors.New("IRC registration failed")
+	ErrSASLFailed      = errors.New("SASL authentication failed")
+)
+
+// IRCConfig is what's needed to connect to an IRC server
+type IRCConfig struct {
+	Server   string // host:port
+	TLS      bool
+	Nick     string
+	Password string // for SASL or NickServ, if set
+	SASL     bool   // authenticate with SASL PLAIN, instead of identifying to NickServ
+	Channels []string
+	Clock    ltime.Clock // tells when messages are received, if nil, the system clock is used
+}
+
+// ircMessage is a parsed IRC protocol line
+type ircMessage struct {
+	tags    map[string]string
+	prefix  string
+	command string
+	params  []string
+}
+
+// nick returns the nick part of the prefix
+func (im ircMessage) nick() string {
+	nick, _, _ := strings.Cut(im.prefix, "!")
+	return nick
+}
+
+func (im ircMessage) param(i int) string {
+	if i >= 0 && i < len(im.params) {
+		return im.params[i]
+	}
+	return ""
+}
+
+// parseIRCLine parses a line, without the trailing CRLF, as described in RFC 1459 and IRCv3 message tags
+func parseIRCLine(line string) (ircMessage, error) {
+	var im ircMessage
+	if strings.HasPrefix(line, "@") {
+		var tags string
+		tags, line, _ = strings.Cut(line[1:], " ")
+		im.tags = make(map[string]string)
+		for _, tag := range strings.Split(tags, ";") {
+			k, v, _ := strings.Cut(tag, "=")
+			im.tags[k] = v
+		}
+	}
+	line = strings.TrimLeft(line, " ")
+	if strings.HasPrefix(line, ":") {
+		im.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	line = strings.TrimLeft(line, " ")
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			im.params = append(im.params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if param != "" {
+			im.params = append(im.params, param)
+		}
+	}
+	if len(im.params) == 0 {
+		return im, fmt.Errorf("invalid IRC line: %q", line)
+	}
+	im.command = strings.ToUpper(im.params[0])
+	im.params = im.params[1:]
+	return im, nil
+}
+
+func isIRCChannel(target string) bool {
+	return target != "" && strings.ContainsRune("#&+!", rune(target[0]))
+}
+
+// splitIRCText splits text into lines that can be sent as single PRIVMSGs
+func splitIRCText(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		for len(line) > ircMaxLineBytes {
+			cut := ircMaxLineBytes
+			// don't split in the middle of a UTF-8 sequence
+			for cut > 0 && line[cut]&0xC0 == 0x80 {
+				cut--
+			}
+			lines = append(lines, line[:cut])
+			line = line[cut:]
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// IRCTransport plays the game in IRC channels, and reconnects with backoff if the connection is lost
+type IRCTransport struct {
+	cfg      IRCConfig
+	logger   zerolog.Logger
+	dial     func(ctx context.Context) (net.Conn, error)
+	conn     net.Conn
+	nick     string    // current nick, which might differ from the configured one if it was taken
+	lastSend time.Time // for rate limiting, to not get kicked for flooding
+	interval time.Duration
+	pending  int // capability requests not answered yet, only used while registering
+	clock    ltime.Clock
+	mu       sync.Mutex // guards conn and nick
+	sendMu   sync.Mutex // keeps lines from different messages from being mixed up, and guards lastSend
+}
+
+func NewIRCTransport(cfg IRCConfig, logger zerolog.Logger) *IRCTransport {
+	it := &IRCTransport{
+		cfg:      cfg,
+		logger:   logger.With().Str("irc_server", cfg.Server).Logger(),
+		nick:     cfg.Nick,
+		interval: ircSendInterval,
+		clock:    ltime.ClockOrReal(cfg.Clock),
+	}
+	it.dial = it.dialServer
+	return it
+}
+
+func (it *IRCTransport) log() *zerolog.Logger {
+	return &it.logger
+}
+
+func (it *IRCTransport) dialServer(ctx context.Context) (net.Conn, error) {
+	if it.cfg.TLS {
+		host, _, _ := net.SplitHostPort(it.cfg.Server)
+		d := tls.Dialer{Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		return d.DialContext(ctx, "tcp", it.cfg.Server)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", it.cfg.Server)
+}
+
+func (it *IRCTransport) UserID() string {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.nick
+}
+
+// Connect makes the first connection, so that errors in the config are found right away
+func (it *IRCTransport) Connect(ctx context.Context) error {
+	if it.cfg.Nick == "" {
+		return errors.New("no IRC nick given")
+	}
+	conn, err := it.dial(ctx)
+	if err != nil {
+		return err
+	}
+	it.setConn(conn)
+	return nil
+}
+
+func (it *IRCTransport) setConn(conn net.Conn) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	it.conn = conn
+}
+
+func (it *IRCTransport) Close() error {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.conn == nil {
+		return nil
+	}
+	// best effort, the connection is closed anyway
+	_, _ = io.WriteString(it.conn, "QUIT :Bye\r\n")
+	err := it.conn.Close()
+	it.conn = nil
+	return err
+}
+
+// writeLine sends a raw line, without rate limiting
+func (it *IRCTransport) writeLine(format string, args ...any) error {
+	it.mu.Lock()
+	conn := it.conn
+	it.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	_, err := fmt.Fprintf(conn, format+"\r\n", args...)
+	return err
+}
+
+// Run handles the connection until ctx is done, and reconnects with increasing delays when it's lost
+func (it *IRCTransport) Run(ctx context.Context, h Handler) error {
+	backoff := ircMinBackoff
+	for {
+		it.mu.Lock()
+		conn := it.conn
+		it.mu.Unlock()
+
+		if conn == nil {
+			var err error
+			if conn, err = it.dial(ctx); err != nil {
+				it.log().Error().Err(err).Msg("Failed to connect")
+			} else {
+				it.setConn(conn)
+			}
+		}
+
+		if conn != nil {
+			started := time.Now()
+			err := it.session(ctx, conn, h)
+			it.mu.Lock()
+			if it.conn == conn {
+				it.conn = nil
+			}
+			it.mu.Unlock()
+			_ = conn.Close()
+			if ctx.Err() != nil {
+				return nil
+			}
+			it.log().Error().Err(err).Msg("Disconnected")
+			if time.Since(started) > ircStableAfter {
+				backoff = ircMinBackoff
+			}
+		}
+
+		it.log().Info().Dur("backoff", backoff).Msg("Reconnecting")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, ircMaxBackoff)
+	}
+}
+
+// session registers on the connection, and handles what comes in until it fails
+func (it *IRCTransport) session(ctx context.Context, conn net.Conn, h Handler) error {
+	// unblock reading when we are told to stop
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	it.mu.Lock()
+	it.nick = it.cfg.Nick
+	it.mu.Unlock()
+
+	// One request per capability, since a request is rejected as a whole if any part of it is
+	caps := []string{ircServerTime}
+	if it.cfg.SASL {
+		caps = append(caps, ircSASL)
+	}
+	it.pending = len(caps)
+	for _, c := range caps {
+		if err := it.writeLine("CAP REQ :%s", c); err != nil {
+			return err
+		}
+	}
+	if err := it.writeLine("NICK %s", it.cfg.Nick); err != nil {
+		return err
+	}
+	if err := it.writeLine("USER %s 0 * :%s", it.cfg.Nick, it.cfg.Nick); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		received := it.clock.Now()
+		line := scanner.Text()
+		im, err := parseIRCLine(line)
+		if err != nil {
+			it.log().Warn().Err(err).Send()
+			continue
+		}
+		if err = it.handle(ctx, im, received, h); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// handle reacts to a message from the server
+func (it *IRCTransport) handle(ctx context.Context, im ircMessage, received time.Time, h Handler) error {
+	self := it.UserID()
+	switch im.command {
+	case "PING":
+		return it.writeLine("PONG :%s", im.param(0))
+	case "CAP":
+		return it.handleCap(im)
+	case "AUTHENTICATE":
+		if im.param(0) != "+" {
+			return nil
+		}
+		auth := base64.StdEncoding.EncodeToString([]byte(it.cfg.Nick + "\x00" + it.cfg.Nick + "\x00" + it.cfg.Password))
+		return it.writeLine("AUTHENTICATE %s", auth)
+	case "903": // RPL_SASLSUCCESS
+		return it.writeLine("CAP END")
+	case "902", "904", "905", "906": // SASL failed in various ways
+		return fmt.Errorf("%w: %s", ErrSASLFailed, im.param(len(im.params)-1))
+	case "433": // ERR_NICKNAMEINUSE
+		it.mu.Lock()
+		it.nick += "_"
+		nick := it.nick
+		it.mu.Unlock()
+		return it.writeLine("NICK %s", nick)
+	case "001": // RPL_WELCOME
+		if nick := im.param(0); nick != "" {
+			it.mu.Lock()
+			it.nick = nick
+			it.mu.Unlock()
+		}
+		if !it.cfg.SASL && it.cfg.Password != "" {
+			if err := it.writeLine("PRIVMSG NickServ :IDENTIFY %s %s", it.cfg.Nick, it.cfg.Password); err != nil {
+				return err
+			}
+		}
+		for _, channel := range it.cfg.Channels {
+			if err := it.writeLine("JOIN %s", channel); err != nil {
+				return err
+			}
+		}
+	case "NICK":
+		if im.nick() == self {
+			it.mu.Lock()
+			it.nick = im.param(0)
+			it.mu.Unlock()
+		}
+	case "JOIN":
+		if im.nick() == self {
+			h.HandleJoin(ctx, im.param(0))
+			it.log().Info().Str("channel", im.param(0)).Msg("Joined channel")
+		}
+	case "PART":
+		if im.nick() == self {
+			h.HandleLeave(ctx, im.param(0))
+			it.log().Info().Str("channel", im.param(0)).Msg("Left channel")
+		}
+	case "KICK":
+		if im.param(1) == self {
+			h.HandleLeave(ctx, im.param(0))
+			it.log().Info().Str("channel", im.param(0)).Str("by", im.nick()).Msg("Kicked from channel")
+		}
+	case "PRIVMSG":
+		target := im.param(0)
+		if !isIRCChannel(target) {
+			// private messages are not part of the game
+			return nil
+		}
+		h.HandleMessage(
+			ctx,
+			Message{
+				RoomID:   target,
+				Sender:   im.nick(),
+				Body:     im.param(1),
+				Sent:     im.serverTime(received),
+				Received: received,
+			},
+		)
+	case "ERROR":
+		return fmt.Errorf("server error: %s", im.param(0))
+	}
+	return nil
+}
+
+// serverTime returns the time the server got the message, if it told us, or else the time we received it
+func (im ircMessage) serverTime(received time.Time) time.Time {
+	if ts, ok := im.tags["time"]; ok {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return t
+		}
+	}
+	return received
+}
+
+func (it *IRCTransport) handleCap(im ircMessage) error {
+	sub := strings.ToUpper(im.param(1))
+	if sub != "ACK" && sub != "NAK" {
+		return nil
+	}
+	caps := strings.Fields(im.param(len(im.params) - 1))
+	if slices.Contains(caps, ircSASL) {
+		if sub == "NAK" {
+			return fmt.Errorf("%w: server does not support SASL", ErrIRCRegistration)
+		}
+		// registration is ended when authentication is done
+		return it.writeLine("AUTHENTICATE PLAIN")
+	}
+	// server-time is nice to have, but we can do without it
+	it.pending--
+	if it.pending == 0 && !it.cfg.SASL {
+		return it.writeLine("CAP END")
+	}
+	return nil
+}
+
+// Send sends each line of the message as a PRIVMSG. IRC has no message IDs, so none is returned.
+func (it *IRCTransport) Send(ctx context.Context, roomID string, content Content) (string, error) {
+	if roomID == "" {
+		return "", ErrNoRoomID
+	}
+	it.sendMu.Lock()
+	defer it.sendMu.Unlock()
+	for _, line := range splitIRCText(content.Text) {
+		if wait := it.interval - time.Since(it.lastSend); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		if err := it.writeLine("PRIVMSG %s :%s", roomID, line); err != nil {
+			return "", err
+		}
+		it.lastSend = time.Now()
+	}
+	return "", nil
+}
+
+// Edit sends the new version as a new message, since IRC messages can't be edited
+func (it *IRCTransport) Edit(ctx context.Context, roomID, _ string, content Content) error {
+	_, err := it.Send(ctx, roomID, content)
+	return err
+}
+
+// React is not supported, as IRC messages have no IDs
+func (it *IRCTransport) React(_ context.Context, _, _, _ string) error {
+	return errors.ErrUnsupported
+}
+
+// DisplayName is the nick, which is what's used as user ID on IRC
+func (it *IRCTransport) DisplayName(_ context.Context, _, userID string) string {
+	return userID
+}
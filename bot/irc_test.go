@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseIRCLine(t *testing.T) {
+	t.Parallel()
+
+	im, err := parseIRCLine("@time=2025-05-12T13:37:00.123Z;account=x :nick!user@host PRIVMSG #leet :!1337 stats")
+	require.NoError(t, err)
+	assert.Equal(t, "PRIVMSG", im.command)
+	assert.Equal(t, "nick", im.nick())
+	assert.Equal(t, []string{"#leet", "!1337 stats"}, im.params)
+	assert.Equal(t, "x", im.tags["account"])
+	assert.True(t, im.serverTime(time.Now()).Equal(time.Date(2025, 5, 12, 13, 37, 0, 123000000, time.UTC)))
+
+	im, err = parseIRCLine("ping :server")
+	require.NoError(t, err)
+	assert.Equal(t, "PING", im.command)
+	assert.Equal(t, "server", im.param(0))
+	assert.Empty(t, im.param(1))
+	assert.Empty(t, im.param(-1))
+	received := time.Now()
+	assert.Equal(t, received, im.serverTime(received))
+
+	_, err = parseIRCLine(":prefix.only")
+	assert.Error(t, err)
+}
+
+func Test_splitIRCText(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"one", "two"}, splitIRCText("one\r\n\ntwo\n"))
+	long := strings.Repeat("a", ircMaxLineBytes-1) + "åäö"
+	lines := splitIRCText(long)
+	require.Len(t, lines, 2)
+	assert.Equal(t, long, strings.Join(lines, ""))
+	assert.Equal(t, strings.Repeat("a", ircMaxLineBytes-1), lines[0], "should not split in the middle of a rune")
+}
+
+// fakeIRCServer answers enough of the protocol to register, and records what the client sends
+type fakeIRCServer struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan string
+}
+
+func newFakeIRCServer(t *testing.T, conn net.Conn) *fakeIRCServer {
+	fs := &fakeIRCServer{t: t, conn: conn, lines: make(chan string, 100)}
+	go func() {
+		defer close(fs.lines)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			fs.lines <- scanner.Text()
+		}
+	}()
+	return fs
+}
+
+func (fs *fakeIRCServer) expect(line string) {
+	fs.t.Helper()
+	select {
+	case got := <-fs.lines:
+		assert.Equal(fs.t, line, got)
+	case <-time.After(5 * time.Second):
+		fs.t.Fatalf("timed out waiting for %q", line)
+	}
+}
+
+func (fs *fakeIRCServer) send(line string) {
+	_, err := fs.conn.Write([]byte(line + "\r\n"))
+	require.NoError(fs.t, err)
+}
+
+type syncHandler struct {
+	recordingHandler
+	mu     sync.Mutex
+	events chan struct{}
+}
+
+func (sh *syncHandler) HandleMessage(ctx context.Context, msg Message) {
+	sh.mu.Lock()
+	sh.recordingHandler.HandleMessage(ctx, msg)
+	sh.mu.Unlock()
+	sh.events <- struct{}{}
+}
+
+func (sh *syncHandler) HandleJoin(ctx context.Context, roomID string) {
+	sh.mu.Lock()
+	sh.recordingHandler.HandleJoin(ctx, roomID)
+	sh.mu.Unlock()
+	sh.events <- struct{}{}
+}
+
+func (sh *syncHandler) HandleLeave(ctx context.Context, roomID string) {
+	sh.mu.Lock()
+	sh.recordingHandler.HandleLeave(ctx, roomID)
+	sh.mu.Unlock()
+	sh.events <- struct{}{}
+}
+
+func Test_IRCTransport_session(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conns := make(chan net.Conn, 2)
+	clock := ltime.NewFakeClock(time.Date(2025, 5, 12, 13, 37, 0, 2000, time.UTC))
+	it := NewIRCTransport(
+		IRCConfig{Nick: "leetbot", Password: "secret", SASL: true, Channels: []string{"#leet"}, Clock: clock},
+		zerolog.Nop(),
+	)
+	it.interval = 0
+	it.dial = func(context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		conns <- server
+		return client, nil
+	}
+	require.NoError(t, it.Connect(ctx))
+
+	h := syncHandler{events: make(chan struct{}, 10)}
+	done := make(chan error)
+	go func() { done <- it.Run(ctx, &h) }()
+
+	fs := newFakeIRCServer(t, <-conns)
+	fs.expect("CAP REQ :server-time")
+	fs.expect("CAP REQ :sasl")
+	fs.expect("NICK leetbot")
+	fs.expect("USER leetbot 0 * :leetbot")
+	fs.send(":server CAP * NAK :server-time")
+	fs.send(":server CAP * ACK :sasl")
+	fs.expect("AUTHENTICATE PLAIN")
+	fs.send("AUTHENTICATE +")
+	fs.expect("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("leetbot\x00leetbot\x00secret")))
+	fs.send(":server 903 leetbot :SASL authentication successful")
+	fs.expect("CAP END")
+	fs.send(":server 433 * leetbot :Nickname is already in use")
+	fs.expect("NICK leetbot_")
+	fs.send(":server 001 leetbot_ :Welcome")
+	fs.expect("JOIN #leet")
+	fs.send(":leetbot_!bot@host JOIN #leet")
+	<-h.events
+	fs.send("PING :server")
+	fs.expect("PONG :server")
+	fs.send("@time=2025-05-12T13:37:00.000001Z :player!p@host PRIVMSG #leet :!1337")
+	<-h.events
+	fs.send(":player!p@host PRIVMSG leetbot_ :private messages are ignored")
+
+	_, err := it.Send(ctx, "#leet", Content{Text: "one\ntwo"})
+	require.NoError(t, err)
+	fs.expect("PRIVMSG #leet :one")
+	fs.expect("PRIVMSG #leet :two")
+
+	fs.send(":op!o@host KICK #leet leetbot_ :bye")
+	<-h.events
+
+	h.mu.Lock()
+	assert.Equal(t, "leetbot_", it.UserID())
+	assert.Equal(t, []string{"#leet"}, h.joined)
+	assert.Equal(t, []string{"#leet"}, h.left)
+	require.Len(t, h.msgs, 1)
+	assert.Equal(t, "player", h.msgs[0].Sender)
+	assert.Equal(t, "#leet", h.msgs[0].RoomID)
+	assert.True(t, h.msgs[0].Sent.Equal(time.Date(2025, 5, 12, 13, 37, 0, 1000, time.UTC)))
+	assert.Equal(t, clock.Now(), h.msgs[0].Received)
+	h.mu.Unlock()
+
+	// losing the connection should make it reconnect
+	require.NoError(t, fs.conn.Close())
+	fs = newFakeIRCServer(t, <-conns)
+	fs.expect("CAP REQ :server-time")
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func Test_IRCTransport_saslFailed(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	defer server.Close()
+	it := NewIRCTransport(IRCConfig{Nick: "leetbot", SASL: true}, zerolog.Nop())
+	it.setConn(client)
+
+	fs := newFakeIRCServer(t, server)
+	done := make(chan error)
+	go func() { done <- it.session(context.Background(), client, &recordingHandler{}) }()
+	fs.expect("CAP REQ :server-time")
+	fs.expect("CAP REQ :sasl")
+	fs.send(":server CAP * NAK :sasl")
+	assert.ErrorIs(t, <-done, ErrIRCRegistration)
+}
@@ -0,0 +1,307 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// See eample at: https://github.com/mautrix/go/blob/main/example/main.go
+
+// MatrixConfig is what's needed to connect to a homeserver
+type MatrixConfig struct {
+	Username string
+	Password string
+	Server   string
+	DBPath   string // where the crypto state is kept, along with the sync token, so that syncing resumes after a restart
+	// HomeserverURL is the client API address, found through .well-known on Server if empty
+	HomeserverURL string
+	Clock         ltime.Clock // tells when events are received, if nil, the system clock is used
+}
+
+// MatrixTransport plays the game in Matrix rooms, with end to end encryption
+type MatrixTransport struct {
+	client       *mautrix.Client
+	syncer       *catchUpSyncer
+	cryptoHelper *cryptohelper.CryptoHelper
+	cfg          MatrixConfig
+	userID       string
+	logger       zerolog.Logger
+	clock        ltime.Clock
+}
+
+var ErrNotConnected = errors.New("not connected")
+
+// catchUpSyncer tells when the first sync after connecting has been processed, which is when
+// everything that happened while we were away has been passed on
+type catchUpSyncer struct {
+	*mautrix.DefaultSyncer
+	caughtUp func(ctx context.Context)
+	once     sync.Once
+}
+
+func (cs *catchUpSyncer) ProcessResponse(ctx context.Context, resp *mautrix.RespSync, since string) error {
+	if err := cs.DefaultSyncer.ProcessResponse(ctx, resp, since); err != nil {
+		return err
+	}
+	if cs.caughtUp != nil {
+		cs.once.Do(func() { cs.caughtUp(ctx) })
+	}
+	return nil
+}
+
+func NewMatrixTransport(cfg MatrixConfig, logger zerolog.Logger) *MatrixTransport {
+	return &MatrixTransport{
+		cfg:    cfg,
+		userID: fmt.Sprintf("@%s:%s", cfg.Username, cfg.Server),
+		logger: logger,
+		clock:  ltime.ClockOrReal(cfg.Clock),
+	}
+}
+
+func (mt *MatrixTransport) log() *zerolog.Logger {
+	return &mt.logger
+}
+
+func (mt *MatrixTransport) UserID() string {
+	return mt.userID
+}
+
+// homeserverURL returns where to reach the client API
+func (mt *MatrixTransport) homeserverURL(ctx context.Context) (string, error) {
+	if mt.cfg.HomeserverURL != "" {
+		return mt.cfg.HomeserverURL, nil
+	}
+	// Find true address of server, in case of delegation.
+	cwk, err := mautrix.DiscoverClientAPI(ctx, mt.cfg.Server)
+	if err != nil {
+		return "", err
+	}
+	// no .well-known means no delegation
+	if cwk == nil {
+		return "https://" + mt.cfg.Server, nil
+	}
+	return cwk.Homeserver.BaseURL, nil
+}
+
+func (mt *MatrixTransport) Connect(ctx context.Context) error {
+	baseURL, err := mt.homeserverURL(ctx)
+	if err != nil {
+		return err
+	}
+
+	mt.client, err = mautrix.NewClient(baseURL, "", "")
+	if err != nil {
+		return err
+	}
+	mt.client.Log = mt.logger
+	mt.syncer = &catchUpSyncer{DefaultSyncer: mautrix.NewDefaultSyncer()}
+	mt.client.Syncer = mt.syncer
+	// adjust the logger now, after having passed on a clean copy to the client
+	mt.logger = mt.logger.With().Str("bot", mt.userID).Logger()
+
+	mt.cryptoHelper, err = cryptohelper.NewCryptoHelper(mt.client, []byte("1337"), mt.cfg.DBPath)
+	if err != nil {
+		return err
+	}
+
+	mt.cryptoHelper.LoginAs = &mautrix.ReqLogin{
+		Type:       mautrix.AuthTypePassword,
+		Identifier: mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: mt.cfg.Username},
+		Password:   mt.cfg.Password,
+	}
+
+	if err = mt.cryptoHelper.Init(ctx); err != nil {
+		return err
+	}
+	mt.client.Crypto = mt.cryptoHelper
+	return nil
+}
+
+func (mt *MatrixTransport) Run(ctx context.Context, h Handler) error {
+	if mt.client == nil {
+		return ErrNotConnected
+	}
+
+	syncer := mt.syncer
+	syncer.caughtUp = h.HandleCaughtUp
+
+	syncer.OnEventType(event.EventMessage, func(ctx context.Context, evt *event.Event) {
+		content := evt.Content.AsMessage()
+		msg := Message{
+			ID:       evt.ID.String(),
+			RoomID:   evt.RoomID.String(),
+			Sender:   evt.Sender.String(),
+			Body:     content.Body,
+			Sent:     time.UnixMilli(evt.Timestamp),
+			Received: mt.clock.Now(),
+		}
+		if content.RelatesTo.GetReplaceID() != "" {
+			msg.Edited = true
+			if content.NewContent != nil {
+				msg.Body = content.NewContent.Body
+			}
+		}
+		h.HandleMessage(ctx, msg)
+	})
+
+	syncer.OnEventType(event.EventRedaction, func(ctx context.Context, evt *event.Event) {
+		redacts := evt.Redacts
+		if redacts == "" {
+			// since room version 11, it's in the content
+			redacts = evt.Content.AsRedaction().Redacts
+		}
+		if redacts != "" {
+			h.HandleRedaction(ctx, evt.RoomID.String(), redacts.String())
+		}
+	})
+
+	syncer.OnEventType(event.StateMember, func(ctx context.Context, evt *event.Event) {
+		if evt.GetStateKey() != mt.client.UserID.String() {
+			return
+		}
+		switch evt.Content.AsMember().Membership {
+		case event.MembershipInvite:
+			if _, err := mt.client.JoinRoomByID(ctx, evt.RoomID); err != nil {
+				mt.log().Error().Err(err).
+					Str("room_id", evt.RoomID.String()).
+					Str("inviter", evt.Sender.String()).
+					Msg("Failed to join room after invite")
+				return
+			}
+			h.HandleJoin(ctx, evt.RoomID.String())
+			mt.log().Info().
+				Str("room_id", evt.RoomID.String()).
+				Str("inviter", evt.Sender.String()).
+				Msg("Joined room after invite")
+		case event.MembershipJoin:
+			h.HandleJoin(ctx, evt.RoomID.String())
+			mt.log().Info().
+				Str("room_id", evt.RoomID.String()).
+				Str("inviter", evt.Sender.String()).
+				Msg("Joined room")
+		case event.MembershipLeave, event.MembershipBan:
+			h.HandleLeave(ctx, evt.RoomID.String())
+			mt.log().Info().
+				Str("room_id", evt.RoomID.String()).
+				Str("sender", evt.Sender.String()).
+				Msg("Left room")
+		}
+	})
+
+	if err := mt.client.SyncWithContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+func (mt *MatrixTransport) Close() error {
+	if mt.cryptoHelper == nil {
+		return nil
+	}
+	return mt.cryptoHelper.Close()
+}
+
+// DisplayName looks the user up in the member state of the room, which is kept up to date while syncing.
+// Names that could be mistaken for someone else's in the room get the user ID added, like clients do.
+func (mt *MatrixTransport) DisplayName(ctx context.Context, roomID, userID string) string {
+	if mt.client == nil || mt.client.StateStore == nil {
+		return userID
+	}
+	member, err := mt.client.StateStore.TryGetMember(ctx, id.RoomID(roomID), id.UserID(userID))
+	if err != nil || member == nil || member.Displayname == "" {
+		return userID
+	}
+	others, err := mt.client.StateStore.IsConfusableName(ctx, id.RoomID(roomID), id.UserID(userID), member.Displayname)
+	if err != nil || len(others) > 0 {
+		return fmt.Sprintf("%s (%s)", member.Displayname, userID)
+	}
+	return member.Displayname
+}
+
+// pill is how a mention is written in HTML
+func pill(userID, name string) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, id.UserID(userID).URI().MatrixToURL(), html.EscapeString(name))
+}
+
+// content converts msg to a Matrix message, where only the users in msg.Mentions are mentioned.
+// They are shown by display name in the text, and as pills in the HTML, which is made from the text if not given.
+func (mt *MatrixTransport) content(ctx context.Context, roomID string, msg Content) *event.MessageEventContent {
+	content := &event.MessageEventContent{
+		MsgType:  event.MsgText,
+		Body:     msg.Text,
+		Mentions: &event.Mentions{},
+	}
+	if msg.ReplyTo != "" {
+		content.RelatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: id.EventID(msg.ReplyTo)}}
+	}
+	formatted := msg.HTML
+	makePills := formatted == "" && len(msg.Mentions) > 0
+	if makePills {
+		formatted = strings.ReplaceAll(html.EscapeString(msg.Text), "\n", "<br>")
+	}
+	for _, userID := range msg.Mentions {
+		name := mt.DisplayName(ctx, roomID, userID)
+		content.Mentions.Add(id.UserID(userID))
+		content.Body = strings.ReplaceAll(content.Body, userID, name)
+		if makePills {
+			formatted = strings.ReplaceAll(formatted, html.EscapeString(userID), pill(userID, name))
+		}
+	}
+	if formatted != "" {
+		content.Format = event.FormatHTML
+		content.FormattedBody = formatted
+	}
+	return content
+}
+
+func (mt *MatrixTransport) sendMessage(ctx context.Context, roomID string, content *event.MessageEventContent) (string, error) {
+	if roomID == "" {
+		return "", ErrNoRoomID
+	}
+
+	if mt.client == nil {
+		return "", ErrNilClient
+	}
+
+	resp, err := mt.client.SendMessageEvent(ctx, id.RoomID(roomID), event.EventMessage, content)
+	if err != nil {
+		return "", err
+	}
+	return resp.EventID.String(), nil
+}
+
+func (mt *MatrixTransport) Send(ctx context.Context, roomID string, content Content) (string, error) {
+	return mt.sendMessage(ctx, roomID, mt.content(ctx, roomID, content))
+}
+
+// React annotates the message with the key, as clients show under the message
+func (mt *MatrixTransport) React(ctx context.Context, roomID, messageID, key string) error {
+	if roomID == "" {
+		return ErrNoRoomID
+	}
+	if mt.client == nil {
+		return ErrNilClient
+	}
+	_, err := mt.client.SendReaction(ctx, id.RoomID(roomID), id.EventID(messageID), key)
+	return err
+}
+
+// Edit replaces the content of a previously sent message
+func (mt *MatrixTransport) Edit(ctx context.Context, roomID, messageID string, content Content) error {
+	c := mt.content(ctx, roomID, content)
+	c.SetEdit(id.EventID(messageID))
+	_, err := mt.sendMessage(ctx, roomID, c)
+	return err
+}
@@ -0,0 +1,330 @@
+package bot
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/bot/fakehs"
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	testRoomID  = `!room:fake`
+	testTimeout = 10 * time.Second
+)
+
+// beforeWindow returns a clock at 13:36:30 today, so that entries at 13:37 are seen as sent after startup
+func beforeWindow() *ltime.FakeClock {
+	now := time.Now()
+	return ltime.NewFakeClock(time.Date(now.Year(), now.Month(), now.Day(), 13, 36, 30, 0, now.Location()))
+}
+
+// startMatrixBot starts a bot on the fake homeserver, with its state in dir, and returns it when it has joined
+// the room on an invite from alice. The config is completed with the rest of what's needed, and a clock from
+// beforeWindow if not set. Cancelling ctx stops the bot, and the error from it is sent on the returned channel.
+func startMatrixBot(
+	ctx context.Context,
+	t *testing.T,
+	hs *fakehs.Server,
+	alice, dir string,
+	cfg BotConfig,
+) (*Bot, <-chan error) {
+	t.Helper()
+
+	botID := hs.AddUser("leetbot", "secret")
+	hs.Join(testRoomID, alice)
+
+	cfg.Server = hs.Name()
+	cfg.DBPath = filepath.Join(dir, "leet.db")
+	cfg.ConfigFile = filepath.Join(dir, "leet.json")
+	cfg.TimeFrame = ltime.TimeFrame{
+		Hour:         13,
+		Minute:       37,
+		WindowBefore: time.Minute,
+		WindowAfter:  time.Minute,
+		Location:     time.Local,
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = beforeWindow()
+	}
+	mt := NewMatrixTransport(
+		MatrixConfig{
+			Username:      "leetbot",
+			Password:      "secret",
+			Server:        hs.Name(),
+			DBPath:        filepath.Join(dir, "crypto.db"),
+			HomeserverURL: hs.URL,
+			Clock:         cfg.Clock,
+		},
+		zerolog.Nop(),
+	)
+	b := New(cfg, mt, zerolog.Nop())
+	assert.Equal(t, botID, b.userID)
+
+	done := make(chan error)
+	go func() { done <- b.Start(ctx) }()
+
+	hs.Invite(testRoomID, alice, botID)
+	require.NoError(t, hs.WaitForJoin(testRoomID, botID, testTimeout))
+	return b, done
+}
+
+func Test_MatrixTransport_endToEnd(t *testing.T) {
+	t.Parallel()
+
+	const (
+		roomID  = testRoomID
+		timeout = testTimeout
+	)
+
+	hs := fakehs.New()
+	defer hs.Close()
+	alice := hs.UserID("alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// the bot and the transport agree on the time, so the skew is only what the sender's clock is off by
+	b, done := startMatrixBot(
+		ctx,
+		t,
+		hs,
+		alice,
+		t.TempDir(),
+		BotConfig{Feedback: FeedbackReply, Trust: TrustPolicy{MaxSkew: time.Minute}},
+	)
+	hs.SetDisplayName(roomID, alice, "Alice <3")
+
+	// the timestamp is set by the sender's server, so it can be anything
+	now := time.Now()
+	target := time.Date(now.Year(), now.Month(), now.Day(), 13, 37, 0, int(123*time.Millisecond), now.Location())
+	entryID := hs.SendText(roomID, alice, "!1337", target)
+	sent, err := hs.WaitForSent(1, timeout)
+	require.NoError(t, err)
+	assert.Equal(t, roomID, sent[0].RoomID)
+	assert.Equal(t, map[string]any{"m.in_reply_to": map[string]any{"event_id": entryID}}, sent[0].Content["m.relates_to"])
+	assert.Contains(t, sent[0].Content["body"], "Alice <3 - +3 points")
+	assert.NotContains(t, sent[0].Content["body"], "Note:")
+	assert.Equal(t, map[string]any{"user_ids": []any{alice}}, sent[0].Content["m.mentions"])
+	assert.Contains(t, sent[0].Content["formatted_body"], `<a href="https://matrix.to/#/`+alice+`">Alice &lt;3</a> - +3 points`)
+
+	hs.SendText(roomID, alice, "!1337 stats", target.Add(time.Second))
+	sent, err = hs.WaitForSent(2, timeout)
+	require.NoError(t, err)
+	assert.Contains(t, sent[1].Content["body"], "Stats since")
+	assert.Equal(t, "org.matrix.custom.html", sent[1].Content["format"])
+	assert.Contains(t, sent[1].Content["formatted_body"], "<table>")
+	assert.Contains(t, sent[1].Content["body"], "Alice <3")
+	assert.Contains(t, sent[1].Content["formatted_body"], "Alice &lt;3")
+	assert.NotContains(t, sent[1].Content["body"], alice)
+	assert.Equal(t, map[string]any{}, sent[1].Content["m.mentions"])
+
+	for l, rooms := range b.gameRooms() {
+		require.NoError(t, b.announceRound(ctx, l, rooms))
+	}
+	sent, err = hs.WaitForSent(3, timeout)
+	require.NoError(t, err)
+	assert.Contains(t, sent[2].Content["body"], "#1 Alice <3")
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Empty(t, hs.Unrecognized())
+}
+
+func Test_MatrixTransport_reactions(t *testing.T) {
+	t.Parallel()
+
+	hs := fakehs.New()
+	defer hs.Close()
+	alice := hs.UserID("alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, done := startMatrixBot(ctx, t, hs, alice, t.TempDir(), BotConfig{Feedback: FeedbackReact})
+
+	now := time.Now()
+	target := time.Date(now.Year(), now.Month(), now.Day(), 13, 37, 0, 0, now.Location())
+	first := hs.SendText(testRoomID, alice, "!1337", target)
+	second := hs.SendText(testRoomID, alice, "!1337", target.Add(time.Second))
+	late := hs.SendText(testRoomID, alice, "!1337", target.Add(time.Hour))
+	sent, err := hs.WaitForSent(3, testTimeout)
+	require.NoError(t, err)
+
+	reactions := make(map[any]any)
+	for _, evt := range sent {
+		assert.Equal(t, "m.reaction", evt.Type)
+		relates, ok := evt.Content["m.relates_to"].(map[string]any)
+		require.True(t, ok)
+		assert.Equal(t, "m.annotation", relates["rel_type"])
+		reactions[relates["event_id"]] = relates["key"]
+	}
+	assert.Equal(t, map[any]any{first: reactScored, second: reactSpam, late: reactMissed}, reactions)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Empty(t, hs.Unrecognized())
+}
+
+func Test_MatrixTransport_editsRedactionsReplays(t *testing.T) {
+	t.Parallel()
+
+	hs := fakehs.New()
+	defer hs.Close()
+	alice := hs.UserID("alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b, done := startMatrixBot(ctx, t, hs, alice, t.TempDir(), BotConfig{Feedback: FeedbackReply})
+
+	now := time.Now()
+	target := time.Date(now.Year(), now.Month(), now.Day(), 13, 37, 0, int(123*time.Millisecond), now.Location())
+	hiID := hs.SendText(testRoomID, alice, "hi", target.Add(-time.Hour))
+	entryID := hs.SendText(testRoomID, alice, "!1337", target)
+	_, err := hs.WaitForSent(1, testTimeout)
+	require.NoError(t, err)
+
+	// the same event again, as after a restart
+	hs.SendEvent(
+		fakehs.Event{
+			Type:           "m.room.message",
+			EventID:        entryID,
+			Sender:         alice,
+			OriginServerTS: target.UnixMilli(),
+			Content:        map[string]any{"msgtype": "m.text", "body": "!1337"},
+			RoomID:         testRoomID,
+		},
+	)
+	// turning an old message into an entry
+	editID := hs.SendEvent(
+		fakehs.Event{
+			Type:           "m.room.message",
+			Sender:         alice,
+			OriginServerTS: target.UnixMilli(),
+			Content: map[string]any{
+				"msgtype":       "m.text",
+				"body":          "* !1337",
+				"m.new_content": map[string]any{"msgtype": "m.text", "body": "!1337"},
+				"m.relates_to":  map[string]any{"rel_type": "m.replace", "event_id": hiID},
+			},
+			RoomID: testRoomID,
+		},
+	)
+	// a replayed entry would have been answered before the edit
+	sent, err := hs.WaitForSent(2, testTimeout)
+	require.NoError(t, err)
+	assert.Contains(t, sent[1].Content["body"], "edited messages don't count")
+	assert.Equal(t, map[string]any{"m.in_reply_to": map[string]any{"event_id": editID}}, sent[1].Content["m.relates_to"])
+
+	hs.SendEvent(
+		fakehs.Event{
+			Type:    "m.room.redaction",
+			Sender:  alice,
+			Content: map[string]any{"redacts": entryID},
+			RoomID:  testRoomID,
+		},
+	)
+	sent, err = hs.WaitForSent(3, testTimeout)
+	require.NoError(t, err)
+	assert.Contains(t, sent[2].Content["body"], "entries can't be taken back")
+
+	// only the first one counted
+	stats, err := b.game(ctx, b.modes[0], testRoomID).Stats()
+	require.NoError(t, err)
+	require.Len(t, stats.Rows, 1)
+	assert.Equal(t, 3, stats.Rows[0].Points)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Empty(t, hs.Unrecognized())
+}
+
+func Test_MatrixTransport_backlog(t *testing.T) {
+	t.Parallel()
+
+	for _, backlog := range []Backlog{BacklogDiscard, BacklogScore} {
+		t.Run(string(backlog), func(t *testing.T) {
+			t.Parallel()
+
+			hs := fakehs.New()
+			defer hs.Close()
+			alice := hs.UserID("alice")
+			bob := hs.UserID("bob")
+			dir := t.TempDir()
+			clock := beforeWindow()
+			target := clock.Now().Add(30*time.Second + 123*time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			_, done := startMatrixBot(ctx, t, hs, alice, dir, BotConfig{Backlog: backlog, Clock: clock})
+			hs.SendText(testRoomID, alice, "!1337", target)
+			_, err := hs.WaitForSent(1, testTimeout)
+			require.NoError(t, err)
+			cancel()
+			require.NoError(t, <-done)
+			syncs := len(hs.SyncTokens())
+
+			// the round goes by while the bot is away
+			hs.Join(testRoomID, bob)
+			hs.SendText(testRoomID, bob, "!1337", target.Add(time.Second))
+			hs.SendText(testRoomID, alice, "!1337 stats", target.Add(2*time.Second))
+			clock.Set(target.Add(10 * time.Minute))
+
+			ctx, cancel = context.WithCancel(context.Background())
+			_, done = startMatrixBot(ctx, t, hs, alice, dir, BotConfig{Backlog: backlog, Clock: clock})
+			n := 1
+			if backlog == BacklogScore {
+				// announced when caught up
+				sent, err := hs.WaitForSent(2, testTimeout)
+				require.NoError(t, err)
+				assert.Contains(t, sent[1].Content["body"], "Results for")
+				assert.Contains(t, sent[1].Content["body"], "#1 "+bob)
+				n++
+			}
+
+			// the old stats command is not answered, but a new one is
+			hs.SendText(testRoomID, alice, "!1337 stats", clock.Now())
+			sent, err := hs.WaitForSent(n+1, testTimeout)
+			require.NoError(t, err)
+			assert.Contains(t, sent[n].Content["body"], "Stats since")
+			if backlog == BacklogScore {
+				assert.Contains(t, sent[n].Content["body"], bob)
+			} else {
+				assert.NotContains(t, sent[n].Content["body"], bob)
+			}
+			assert.NotEmpty(t, hs.SyncTokens()[syncs], "syncing should resume where it left off")
+
+			cancel()
+			require.NoError(t, <-done)
+			assert.Empty(t, hs.Unrecognized())
+		})
+	}
+}
+
+func Test_MatrixTransport_content(t *testing.T) {
+	t.Parallel()
+
+	mt := &MatrixTransport{}
+	const user = `@bob:fake`
+
+	c := mt.content(context.Background(), `!room:fake`, Content{Text: "plain"})
+	assert.Equal(t, "plain", c.Body)
+	assert.Empty(t, c.FormattedBody)
+	assert.NotNil(t, c.Mentions)
+	assert.Empty(t, c.Mentions.UserIDs)
+
+	c = mt.content(context.Background(), `!room:fake`, Content{Text: "<b>\n: " + user + " - Stop spamming!", Mentions: []string{user}})
+	assert.Equal(t, "<b>\n: "+user+" - Stop spamming!", c.Body)
+	assert.Equal(t, `&lt;b&gt;<br>: <a href="https://matrix.to/#/@bob:fake">@bob:fake</a> - Stop spamming!`, c.FormattedBody)
+	assert.Equal(t, event.FormatHTML, c.Format)
+	assert.Equal(t, []id.UserID{user}, c.Mentions.UserIDs)
+
+	c = mt.content(context.Background(), `!room:fake`, Content{Text: "text", HTML: "<p>" + user + "</p>", Mentions: []string{user}})
+	assert.Equal(t, "<p>"+user+"</p>", c.FormattedBody)
+	assert.Equal(t, []id.UserID{user}, c.Mentions.UserIDs)
+	assert.Nil(t, c.RelatesTo)
+
+	c = mt.content(context.Background(), `!room:fake`, Content{Text: "reply", ReplyTo: "$entry"})
+	assert.Equal(t, id.EventID("$entry"), c.RelatesTo.GetReplyTo())
+}
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// MultiTransport plays the game over several transports at once, e.g. both in an IRC channel and a Matrix room.
+// Messages to a room are sent over the transport the room was seen on.
+type MultiTransport struct {
+	transports []Transport
+	rooms      map[string]Transport
+	mu         sync.RWMutex // guards rooms
+}
+
+func NewMultiTransport(transports ...Transport) *MultiTransport {
+	return &MultiTransport{
+		transports: transports,
+		rooms:      make(map[string]Transport),
+	}
+}
+
+// UserID returns the ID of the first transport. Messages from ourselves on the others are filtered out in Run.
+func (mt *MultiTransport) UserID() string {
+	if len(mt.transports) == 0 {
+		return ""
+	}
+	return mt.transports[0].UserID()
+}
+
+func (mt *MultiTransport) Connect(ctx context.Context) error {
+	for _, t := range mt.transports {
+		if err := t.Connect(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mt *MultiTransport) Close() error {
+	var errs []error
+	for _, t := range mt.transports {
+		errs = append(errs, t.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Run runs all transports, until all of them are done
+func (mt *MultiTransport) Run(ctx context.Context, h Handler) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(mt.transports))
+	)
+	for i, t := range mt.transports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = t.Run(ctx, &routingHandler{mt: mt, t: t, h: h})
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (mt *MultiTransport) route(roomID string) (Transport, error) {
+	if roomID == "" {
+		return nil, ErrNoRoomID
+	}
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	t, ok := mt.rooms[roomID]
+	if !ok {
+		return nil, ErrNoGame
+	}
+	return t, nil
+}
+
+func (mt *MultiTransport) Send(ctx context.Context, roomID string, content Content) (string, error) {
+	t, err := mt.route(roomID)
+	if err != nil {
+		return "", err
+	}
+	return t.Send(ctx, roomID, content)
+}
+
+func (mt *MultiTransport) Edit(ctx context.Context, roomID, messageID string, content Content) error {
+	t, err := mt.route(roomID)
+	if err != nil {
+		return err
+	}
+	return t.Edit(ctx, roomID, messageID, content)
+}
+
+func (mt *MultiTransport) React(ctx context.Context, roomID, messageID, key string) error {
+	t, err := mt.route(roomID)
+	if err != nil {
+		return err
+	}
+	return t.React(ctx, roomID, messageID, key)
+}
+
+// routingHandler remembers which transport each room belongs to, before passing events on
+type routingHandler struct {
+	mt *MultiTransport
+	t  Transport
+	h  Handler
+}
+
+func (rh *routingHandler) remember(roomID string) {
+	rh.mt.mu.Lock()
+	defer rh.mt.mu.Unlock()
+	rh.mt.rooms[roomID] = rh.t
+}
+
+func (rh *routingHandler) HandleMessage(ctx context.Context, msg Message) {
+	if msg.Sender == rh.t.UserID() {
+		return
+	}
+	rh.remember(msg.RoomID)
+	rh.h.HandleMessage(ctx, msg)
+}
+
+func (rh *routingHandler) HandleJoin(ctx context.Context, roomID string) {
+	rh.remember(roomID)
+	rh.h.HandleJoin(ctx, roomID)
+}
+
+func (rh *routingHandler) HandleRedaction(ctx context.Context, roomID, messageID string) {
+	rh.h.HandleRedaction(ctx, roomID, messageID)
+}
+
+func (rh *routingHandler) HandleCaughtUp(ctx context.Context) {
+	rh.h.HandleCaughtUp(ctx)
+}
+
+func (rh *routingHandler) HandleLeave(ctx context.Context, roomID string) {
+	rh.mt.mu.Lock()
+	delete(rh.mt.rooms, roomID)
+	rh.mt.mu.Unlock()
+	rh.h.HandleLeave(ctx, roomID)
+}
+
+func (mt *MultiTransport) DisplayName(ctx context.Context, roomID, userID string) string {
+	t, err := mt.route(roomID)
+	if err != nil {
+		return userID
+	}
+	return t.DisplayName(ctx, roomID, userID)
+}
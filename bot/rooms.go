@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/oddlid/leetbot_matrix/leet"
+	"github.com/oddlid/leetbot_matrix/ltime"
+)
+
+// sharedGameKey identifies the game shared by all rooms, when the leaderboard is shared
+const sharedGameKey = `shared`
+
+// roomConfigPath returns the config file path for the given room, derived from the base path.
+// E.g. "/tmp/leetbot_config.json" and "!abc:test.com" gives "/tmp/leetbot_config_abc_test.com.json".
+func roomConfigPath(base string, roomID string) string {
+	if base == "" {
+		return ""
+	}
+	safe := strings.Trim(
+		strings.Map(
+			func(r rune) rune {
+				switch {
+				case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+					return r
+				default:
+					return '_'
+				}
+			},
+			roomID,
+		),
+		"_",
+	)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "_" + safe + ext
+}
+
+// modeConfigPath returns the base config file path for the games of the given mode, derived from the base path
+// of the main game. E.g. "/tmp/leetbot_config.json" and "evening" gives "/tmp/leetbot_config_evening.json".
+func modeConfigPath(base string, mode string) string {
+	if base == "" || mode == "" {
+		return base
+	}
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "_" + mode + ext
+}
+
+// modeKey returns the key in the store for the game of the given mode, derived from the key of the main game.
+// E.g. "!abc:test.com" and "evening" gives "!abc:test.com/evening".
+func modeKey(key string, mode string) string {
+	if mode == "" {
+		return key
+	}
+	return key + "/" + mode
+}
+
+// newGame creates a game and loads its state, if any
+func (b *Bot) newGame(ctx context.Context, key, configFile string, roomID string, tf ltime.TimeFrame) *leet.Leet {
+	l := leet.New(
+		b.logger,
+		leet.Config{
+			Store:      b.store,
+			Key:        key,
+			ConfigFile: configFile,
+			Room:       roomID,
+			TimeFrame:  tf,
+			Clock:      b.clock,
+		},
+	)
+	if err := l.Load(ctx); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		b.log().Error().Err(err).Str("game", key).Str("config_file", configFile).Msg("Failed to load game")
+	}
+	return l
+}
+
+// game returns the game of the mode for the given room, and creates it if this is the first time we see the room.
+// When the leaderboard is shared, all rooms get the same game of each mode.
+func (b *Bot) game(ctx context.Context, m *gameMode, roomID string) *leet.Leet {
+	if b == nil || m == nil || roomID == "" {
+		return nil
+	}
+
+	key := modeRoom{m.name, roomID}
+	b.gamesMu.RLock()
+	l, ok := b.games[key]
+	b.gamesMu.RUnlock()
+	if ok {
+		return l
+	}
+
+	b.gamesMu.Lock()
+	defer b.gamesMu.Unlock()
+	// might have been added while we waited for the lock
+	if l, ok = b.games[key]; ok {
+		return l
+	}
+	if b.cfg.SharedLeaderboard {
+		if l, ok = b.shared[m.name]; !ok {
+			l = b.newGame(ctx, modeKey(sharedGameKey, m.name), modeConfigPath(b.cfg.ConfigFile, m.name), b.cfg.Room, m.tf)
+			b.shared[m.name] = l
+		}
+	} else {
+		l = b.newGame(ctx, modeKey(roomID, m.name), roomConfigPath(modeConfigPath(b.cfg.ConfigFile, m.name), roomID), roomID, m.tf)
+	}
+	b.games[key] = l
+	b.log().Debug().Str("room_id", roomID).Str("game", m.name).Msg("Added game for room")
+
+	return l
+}
+
+// removeGame stops playing in the given room, saving its games first
+func (b *Bot) removeGame(ctx context.Context, roomID string) {
+	var removed []*leet.Leet
+	b.gamesMu.Lock()
+	for key, l := range b.games {
+		if key.roomID != roomID {
+			continue
+		}
+		delete(b.games, key)
+		if l != b.shared[key.mode] {
+			removed = append(removed, l)
+		}
+	}
+	b.gamesMu.Unlock()
+
+	for _, l := range removed {
+		if err := l.Save(ctx); err != nil {
+			b.log().Error().Err(err).Str("room_id", roomID).Msg("Failed to save game for room")
+		}
+		l.Close()
+	}
+}
+
+// closeGames stops all games, which must be saved first
+func (b *Bot) closeGames() {
+	for l := range b.gameRooms() {
+		l.Close()
+	}
+}
+
+// gameRooms returns each distinct game, and the rooms it is played in
+func (b *Bot) gameRooms() map[*leet.Leet][]string {
+	return b.gameRoomsOf(nil)
+}
+
+// gameRoomsOf is like gameRooms, but only for the games of the given mode, or all games if nil
+func (b *Bot) gameRoomsOf(m *gameMode) map[*leet.Leet][]string {
+	b.gamesMu.RLock()
+	defer b.gamesMu.RUnlock()
+	res := make(map[*leet.Leet][]string, len(b.games))
+	for key, l := range b.games {
+		if m != nil && key.mode != m.name {
+			continue
+		}
+		res[l] = append(res[l], key.roomID)
+	}
+	return res
+}
+
+// loadGames loads the game for the room that the config file was written for,
+// before multiple rooms were supported. The shared game is loaded when first used.
+func (b *Bot) loadGames(ctx context.Context) {
+	if b.cfg.SharedLeaderboard {
+		return
+	}
+
+	// We need to peek into the config file to find which room it belongs to
+	legacy := leet.New(b.logger, leet.Config{ConfigFile: b.cfg.ConfigFile})
+	defer legacy.Close()
+	if err := legacy.LoadConfigFile(); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			b.log().Error().Err(err).Msg("Failed to load config file!")
+		}
+		return
+	}
+	room, _ := legacy.GetRoom()
+	if room == "" {
+		return
+	}
+
+	m := b.modes[0]
+	l := b.newGame(ctx, room, b.cfg.ConfigFile, room, m.tf)
+
+	b.gamesMu.Lock()
+	defer b.gamesMu.Unlock()
+	b.games[modeRoom{m.name, room}] = l
+}
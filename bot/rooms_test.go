@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_roomConfigPath(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, roomConfigPath("", "!abc:test.com"))
+	assert.Equal(t, "/tmp/leetbot_config_abc_test.com.json", roomConfigPath("/tmp/leetbot_config.json", "!abc:test.com"))
+	assert.Equal(t, "/tmp/config_abc_test.com", roomConfigPath("/tmp/config", "!abc:test.com"))
+}
+
+func Test_modeConfigPath(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, modeConfigPath("", "evening"))
+	assert.Equal(t, "/tmp/leetbot_config.json", modeConfigPath("/tmp/leetbot_config.json", ""))
+	assert.Equal(t, "/tmp/leetbot_config_evening.json", modeConfigPath("/tmp/leetbot_config.json", "evening"))
+}
+
+func Test_modeKey(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "!abc:test.com", modeKey("!abc:test.com", ""))
+	assert.Equal(t, "!abc:test.com/evening", modeKey("!abc:test.com", "evening"))
+}
+
+func Test_Bot_game(t *testing.T) {
+	t.Parallel()
+
+	const (
+		room1 = "!one:test.com"
+		room2 = "!two:test.com"
+	)
+	cfg := BotConfig{ConfigFile: filepath.Join(t.TempDir(), "config.json")}
+	ctx := context.Background()
+
+	assert.Nil(t, (*Bot)(nil).game(ctx, nil, room1))
+
+	b := New(cfg, nil, zerolog.Nop())
+	assert.Nil(t, b.game(ctx, b.modes[0], ""))
+	g1 := b.game(ctx, b.modes[0], room1)
+	g2 := b.game(ctx, b.modes[0], room2)
+	assert.NotNil(t, g1)
+	assert.NotSame(t, g1, g2)
+	assert.Same(t, g1, b.game(ctx, b.modes[0], room1))
+	room, err := g1.GetRoom()
+	assert.NoError(t, err)
+	assert.Equal(t, room1, room)
+	assert.Len(t, b.gameRooms(), 2)
+
+	b.removeGame(ctx, room2)
+	assert.Len(t, b.gameRooms(), 1)
+	assert.FileExists(t, roomConfigPath(cfg.ConfigFile, room2))
+
+	cfg.SharedLeaderboard = true
+	b = New(cfg, nil, zerolog.Nop())
+	g1 = b.game(ctx, b.modes[0], room1)
+	assert.Same(t, g1, b.game(ctx, b.modes[0], room2))
+	gr := b.gameRooms()
+	assert.Len(t, gr, 1)
+	assert.ElementsMatch(t, []string{room1, room2}, gr[g1])
+}
+
+func Test_Bot_game_modes(t *testing.T) {
+	t.Parallel()
+
+	const (
+		room1 = "!one:test.com"
+		room2 = "!two:test.com"
+	)
+	cfg := BotConfig{
+		ConfigFile: filepath.Join(t.TempDir(), "config.json"),
+		TimeFrame:  ltime.TimeFrame{Hour: 13, Minute: 37},
+		Games:      []GameConfig{{Name: "evening", TimeFrame: ltime.TimeFrame{Hour: 22, Minute: 22}}},
+	}
+	ctx := context.Background()
+
+	b := New(cfg, nil, zerolog.Nop())
+	require.Len(t, b.modes, 2)
+	main, evening := b.modes[0], b.modes[1]
+	b.HandleJoin(ctx, room1)
+	b.HandleJoin(ctx, room2)
+	assert.Len(t, b.gameRooms(), 4)
+	assert.Len(t, b.gameRoomsOf(evening), 2)
+	g := b.game(ctx, evening, room1)
+	assert.NotSame(t, b.game(ctx, main, room1), g)
+	assert.Equal(t, 2222, g.TimeFrame().GetTargetScore())
+
+	b.removeGame(ctx, room1)
+	assert.Len(t, b.gameRooms(), 2)
+	assert.FileExists(t, roomConfigPath(cfg.ConfigFile, room1))
+	assert.FileExists(t, roomConfigPath(modeConfigPath(cfg.ConfigFile, "evening"), room1))
+
+	cfg.SharedLeaderboard = true
+	b = New(cfg, nil, zerolog.Nop())
+	main, evening = b.modes[0], b.modes[1]
+	b.HandleJoin(ctx, room1)
+	b.HandleJoin(ctx, room2)
+	assert.Len(t, b.gameRooms(), 2)
+	assert.Same(t, b.game(ctx, evening, room1), b.game(ctx, evening, room2))
+	assert.NotSame(t, b.game(ctx, main, room1), b.game(ctx, evening, room1))
+
+	// the shared games are kept when leaving a room
+	b.removeGame(ctx, room1)
+	assert.Len(t, b.gameRooms(), 2)
+	assert.Same(t, b.shared["evening"], b.game(ctx, evening, room2))
+}
@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/robfig/cron/v3"
+)
+
+// Scheduler runs jobs on cron specs with seconds. *cron.Cron is the real one.
+type Scheduler interface {
+	AddFunc(spec string, cmd func()) (cron.EntryID, error)
+	Entries() []cron.Entry
+	Start()
+	Stop() context.Context
+}
+
+// FakeCron is a Scheduler driven by a fake clock, for running days of rounds in no time.
+// Jobs only run from Advance, and then on the goroutine calling it, in the order they are due.
+type FakeCron struct {
+	clock   *ltime.FakeClock
+	parser  cron.Parser
+	entries []*cron.Entry
+	nextID  cron.EntryID
+	running bool
+	mu      sync.Mutex
+}
+
+func NewFakeCron(clock *ltime.FakeClock) *FakeCron {
+	return &FakeCron{
+		clock:  clock,
+		parser: cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor),
+	}
+}
+
+func (fc *FakeCron) AddFunc(spec string, cmd func()) (cron.EntryID, error) {
+	schedule, err := fc.parser.Parse(spec)
+	if err != nil {
+		return 0, err
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.nextID++
+	fc.entries = append(
+		fc.entries,
+		&cron.Entry{
+			ID:         fc.nextID,
+			Schedule:   schedule,
+			Next:       schedule.Next(fc.clock.Now()),
+			Job:        cron.FuncJob(cmd),
+			WrappedJob: cron.FuncJob(cmd),
+		},
+	)
+	return fc.nextID, nil
+}
+
+// Entries returns a copy of the entries, soonest first
+func (fc *FakeCron) Entries() []cron.Entry {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	entries := make([]cron.Entry, 0, len(fc.entries))
+	for _, e := range fc.entries {
+		entries = append(entries, *e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Next.Before(entries[j].Next) })
+	return entries
+}
+
+// Start starts running jobs, with the first run of each at its next time from now, like cron does
+func (fc *FakeCron) Start() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.running {
+		return
+	}
+	fc.running = true
+	now := fc.clock.Now()
+	for _, e := range fc.entries {
+		e.Next = e.Schedule.Next(now)
+	}
+}
+
+// Stop stops running jobs. Since jobs run synchronously in Advance, there's nothing to wait for.
+func (fc *FakeCron) Stop() context.Context {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.running = false
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// due returns the first entry that should run no later than until, if started
+func (fc *FakeCron) due(until time.Time) *cron.Entry {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if !fc.running {
+		return nil
+	}
+	var next *cron.Entry
+	for _, e := range fc.entries {
+		if e.Next.IsZero() || e.Next.After(until) {
+			continue
+		}
+		if next == nil || e.Next.Before(next.Next) {
+			next = e
+		}
+	}
+	return next
+}
+
+// Advance moves the clock d forward, stopping at the time of each job that is due on the way, to run it.
+// It returns the number of jobs run.
+func (fc *FakeCron) Advance(d time.Duration) int {
+	return fc.AdvanceTo(fc.clock.Now().Add(d))
+}
+
+// AdvanceTo is like Advance, but moves the clock to t
+func (fc *FakeCron) AdvanceTo(t time.Time) int {
+	ran := 0
+	for e := fc.due(t); e != nil; e = fc.due(t) {
+		fc.clock.Set(e.Next)
+		fc.mu.Lock()
+		e.Prev = e.Next
+		e.Next = e.Schedule.Next(e.Next)
+		fc.mu.Unlock()
+		e.Job.Run()
+		ran++
+	}
+	if t.After(fc.clock.Now()) {
+		fc.clock.Set(t)
+	}
+	return ran
+}
@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FakeCron(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+	clock := ltime.NewFakeClock(start)
+	fc := NewFakeCron(clock)
+
+	var ran []string
+	_, err := fc.AddFunc("0 37 13 * * *", func() { ran = append(ran, "leet:"+clock.Now().Format(time.DateTime)) })
+	require.NoError(t, err)
+	_, err = fc.AddFunc("0 0 13 * * *", func() { ran = append(ran, "one:"+clock.Now().Format(time.DateTime)) })
+	require.NoError(t, err)
+	_, err = fc.AddFunc("not a spec", func() {})
+	require.Error(t, err)
+
+	entries := fc.Entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, start.Add(time.Hour), entries[0].Next)
+
+	// nothing runs before start
+	assert.Zero(t, fc.Advance(2*time.Hour))
+	assert.Empty(t, ran)
+
+	// what was missed before start is not run
+	fc.Start()
+	assert.Equal(t, 4, fc.Advance(48*time.Hour))
+	assert.Equal(
+		t,
+		[]string{
+			"one:2025-01-03 13:00:00",
+			"leet:2025-01-03 13:37:00",
+			"one:2025-01-04 13:00:00",
+			"leet:2025-01-04 13:37:00",
+		},
+		ran,
+	)
+	assert.Equal(t, start.Add(50*time.Hour), clock.Now())
+
+	<-fc.Stop().Done()
+	assert.Zero(t, fc.Advance(48*time.Hour))
+}
+
+func Test_Bot_rounds(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	day := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+	clock := ltime.NewFakeClock(day)
+	fc := NewFakeCron(clock)
+	var buf strings.Builder
+	ct := NewConsoleTransport(nil, &buf, "bob", clock)
+	b := New(
+		BotConfig{
+			TimeFrame: ltime.TimeFrame{
+				Hour:         13,
+				Minute:       37,
+				WindowBefore: time.Minute,
+				WindowAfter:  time.Minute,
+			},
+			Clock:     clock,
+			Scheduler: fc,
+		},
+		ct,
+		zerolog.Nop(),
+	)
+	require.NoError(t, b.scheduleRound(ctx))
+	fc.Start()
+	b.HandleJoin(ctx, consoleRoom)
+
+	play := func(sender string, offset time.Duration) {
+		ts := b.cfg.TimeFrame.Target(clock.Now()).Add(offset)
+		b.HandleMessage(ctx, Message{RoomID: consoleRoom, Sender: sender, Body: "!1337", Sent: ts, Received: ts})
+	}
+
+	for range 3 {
+		fc.AdvanceTo(day.Add(97 * time.Minute)) // 13:37, the round is open
+		play("bob", 100*time.Millisecond)
+		play("alice", 2*time.Second)
+		fc.AdvanceTo(day.Add(24 * time.Hour)) // the round is closed and announced on the way
+		day = day.Add(24 * time.Hour)
+	}
+
+	out := buf.String()
+	assert.Equal(t, 3, strings.Count(out, "Results for "))
+	for _, date := range []string{"2025-01-02", "2025-01-03", "2025-01-04"} {
+		assert.Contains(t, out, "Results for "+date)
+	}
+	assert.Contains(t, out, "bob - +3 points. Total: 9")
+
+	for l := range b.gameRooms() {
+		stats, err := l.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, day.AddDate(0, 0, -3), stats.Since)
+	}
+}
+
+func Test_Bot_rounds_timeZone(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	loc, err := time.LoadLocation("Europe/Stockholm")
+	require.NoError(t, err)
+	// a host running in UTC, with a game in Stockholm, over the days the clocks go forward
+	day := time.Date(2025, 3, 29, 8, 0, 0, 0, time.UTC)
+	clock := ltime.NewFakeClock(day)
+	fc := NewFakeCron(clock)
+	var buf strings.Builder
+	ct := NewConsoleTransport(nil, &buf, "bob", clock)
+	b := New(
+		BotConfig{
+			TimeFrame: ltime.TimeFrame{
+				Hour:         13,
+				Minute:       37,
+				WindowBefore: time.Minute,
+				WindowAfter:  time.Minute,
+				Location:     loc,
+			},
+			Clock:     clock,
+			Scheduler: fc,
+		},
+		ct,
+		zerolog.Nop(),
+	)
+	require.NoError(t, b.scheduleRound(ctx))
+	fc.Start()
+	b.HandleJoin(ctx, consoleRoom)
+
+	var opens []time.Time
+	_, err = fc.AddFunc(ltime.CronSpecAt(b.cfg.TimeFrame.Target(day).Add(-time.Minute)), func() { opens = append(opens, clock.Now()) })
+	require.NoError(t, err)
+
+	for range 3 {
+		target := b.cfg.TimeFrame.Target(clock.Now())
+		fc.AdvanceTo(target)
+		ts := target.Add(100 * time.Millisecond).UTC()
+		b.HandleMessage(ctx, Message{RoomID: consoleRoom, Sender: "bob", Body: "!1337", Sent: ts, Received: ts})
+		day = day.Add(24 * time.Hour)
+		fc.AdvanceTo(day)
+	}
+
+	assert.Equal(
+		t,
+		[]time.Time{
+			time.Date(2025, 3, 29, 12, 36, 0, 0, time.UTC),
+			time.Date(2025, 3, 30, 11, 36, 0, 0, time.UTC),
+			time.Date(2025, 3, 31, 11, 36, 0, 0, time.UTC),
+		},
+		utc(opens),
+	)
+	out := buf.String()
+	for _, date := range []string{"2025-03-29", "2025-03-30", "2025-03-31"} {
+		assert.Contains(t, out, "Results for "+date+":\n#1 bob [13:37:00:100")
+	}
+	assert.Contains(t, out, "bob - +3 points. Total: 9")
+}
+
+func utc(ts []time.Time) []time.Time {
+	out := make([]time.Time, len(ts))
+	for i, t := range ts {
+		out[i] = t.UTC()
+	}
+	return out
+}
+
+func Test_Bot_rounds_modes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	day := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	clock := ltime.NewFakeClock(day)
+	fc := NewFakeCron(clock)
+	var buf strings.Builder
+	ct := NewConsoleTransport(nil, &buf, "bob", clock)
+	tf := ltime.TimeFrame{Hour: 13, Minute: 37, WindowBefore: time.Minute, WindowAfter: time.Minute}
+	evening, night := tf, tf
+	evening.Hour, evening.Minute = 22, 22
+	night.Hour, night.Minute = 4, 20
+	b := New(
+		BotConfig{
+			TimeFrame: tf,
+			Games:     []GameConfig{{Name: "evening", TimeFrame: evening}, {Name: "night", TimeFrame: night}},
+			Clock:     clock,
+			Scheduler: fc,
+		},
+		ct,
+		zerolog.Nop(),
+	)
+	require.NoError(t, b.checkModes())
+	require.NoError(t, b.scheduleRound(ctx))
+	fc.Start()
+	b.HandleJoin(ctx, consoleRoom)
+
+	play := func(body string, tf ltime.TimeFrame, sender string) {
+		target := tf.Target(clock.Now())
+		fc.AdvanceTo(target)
+		ts := target.Add(100 * time.Millisecond)
+		b.HandleMessage(ctx, Message{RoomID: consoleRoom, Sender: sender, Body: body, Sent: ts, Received: ts})
+	}
+	play("!0420", night, "bob")
+	play("!1337", tf, "alice")
+	play("!2222", evening, "bob")
+	// entries for one game don't count in the others
+	play("!1337", evening, "carol")
+	fc.AdvanceTo(day.Add(24 * time.Hour))
+
+	out := buf.String()
+	assert.Contains(t, out, "Results for 2025-01-02:\n#1 bob [04:20:00:100")
+	assert.Contains(t, out, "Results for 2025-01-02:\n#1 alice [13:37:00:100")
+	assert.Contains(t, out, "Results for 2025-01-02:\n#1 bob [22:22:00:100")
+	assert.Contains(t, out, "Check your time, carol!")
+	assert.Equal(t, 3, strings.Count(out, "Results for "))
+
+	for l := range b.gameRooms() {
+		stats, err := l.Stats()
+		require.NoError(t, err)
+		require.Len(t, stats.Rows, 1)
+		assert.Equal(t, 3, stats.Rows[0].Points)
+	}
+}
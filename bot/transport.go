@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"context"
+	"time"
+)
+
+// Message is an incoming message, as received by a transport
+type Message struct {
+	ID       string // transport specific ID of the message, if any
+	RoomID   string
+	Sender   string
+	Body     string
+	Sent     time.Time // when the message was sent, according to the sender's server
+	Received time.Time // when the message was received by us
+	// Edited is set when the message is a new version of an earlier one, in which case Body is the new version,
+	// and ID is the ID of the edit, not of the original message
+	Edited bool
+}
+
+// Content is an outgoing message.
+// HTML is optional, and only used by transports that support formatted messages.
+type Content struct {
+	Text string
+	HTML string
+	// Mentions are the user IDs of the players the message is addressed to, as written in Text.
+	// Transports that support mentions show them as such, and nobody else is notified.
+	Mentions []string
+	// ReplyTo is the ID of the message this is a reply to, if any.
+	// Transports that don't have replies send it as a plain message.
+	ReplyTo string
+}
+
+// Handler receives what comes in from a transport
+type Handler interface {
+	HandleMessage(ctx context.Context, msg Message)
+	// HandleJoin is called when we have joined a room, or when a room we were in is seen on startup
+	HandleJoin(ctx context.Context, roomID string)
+	// HandleLeave is called when we have left, or been kicked or banned from, a room
+	HandleLeave(ctx context.Context, roomID string)
+	// HandleRedaction is called when a message has been taken back by the sender, or removed by a moderator
+	HandleRedaction(ctx context.Context, roomID, messageID string)
+	// HandleCaughtUp is called when what was missed while we were away has been passed on.
+	// Transports that don't catch up, or can't tell when they're done, don't call it.
+	HandleCaughtUp(ctx context.Context)
+}
+
+// Transport connects the bot to the place where the game is played
+type Transport interface {
+	// Connect logs in, and whatever else is needed before Run can be called
+	Connect(ctx context.Context) error
+	// Run passes incoming events to h, until ctx is done or the connection is lost for good
+	Run(ctx context.Context, h Handler) error
+	// Close releases what was set up by Connect
+	Close() error
+	// UserID returns the ID that messages from the bot itself come from
+	UserID() string
+	// Send sends a message to the room, and returns its ID, if the transport has IDs for messages
+	Send(ctx context.Context, roomID string, content Content) (string, error)
+	// Edit replaces a previously sent message, or sends a new one if the transport can't edit messages
+	Edit(ctx context.Context, roomID, messageID string, content Content) error
+	// React puts a reaction, like an emoji, on a message. Transports that can't do that return errors.ErrUnsupported.
+	React(ctx context.Context, roomID, messageID, key string) error
+	// DisplayName returns the name to show for the user in the room, which is the user ID if there is no other
+	DisplayName(ctx context.Context, roomID, userID string) string
+}
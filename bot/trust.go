@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// skewWeight is how much each new sample counts when updating the average skew for a server
+const skewWeight = 0.2
+
+// TrustPolicy decides how much we trust origin_server_ts of events from other servers.
+// The server the bot is on is always trusted.
+type TrustPolicy struct {
+	TrustedServers []string      // servers whose timestamps we trust, in addition to our own
+	MaxSkew        time.Duration // max difference between origin_server_ts and time of receipt, 0 to disable checks
+}
+
+type trustVerdict uint8
+
+const (
+	trustOK       trustVerdict = iota // entry is accepted as is
+	trustFlagged                      // entry is accepted, but the player is told why it's suspicious
+	trustRejected                     // entry is not accepted
+)
+
+type trustResult struct {
+	verdict trustVerdict
+	reason  string // explanation to the player, when not trustOK
+}
+
+// serverSkew keeps track of the difference between origin_server_ts and the time we receive events from a server
+type serverSkew struct {
+	samples int
+	last    time.Duration
+	avg     time.Duration // exponential moving average
+}
+
+func (ss *serverSkew) add(skew time.Duration) {
+	ss.samples++
+	ss.last = skew
+	if ss.samples == 1 {
+		ss.avg = skew
+		return
+	}
+	ss.avg += time.Duration(skewWeight * float64(skew-ss.avg))
+}
+
+type trustChecker struct {
+	maxSkew time.Duration
+	trusted map[string]bool
+	skews   map[string]*serverSkew
+	mu      sync.Mutex // guards skews
+}
+
+func newTrustChecker(policy TrustPolicy, ownServer string) *trustChecker {
+	tc := trustChecker{
+		maxSkew: policy.MaxSkew,
+		trusted: make(map[string]bool, len(policy.TrustedServers)+1),
+		skews:   make(map[string]*serverSkew),
+	}
+	tc.trusted[ownServer] = true
+	for _, s := range policy.TrustedServers {
+		tc.trusted[s] = true
+	}
+	return &tc
+}
+
+// serverOf returns the server part of a user ID
+func serverOf(userID string) string {
+	_, server, found := strings.Cut(userID, ":")
+	if !found {
+		return ""
+	}
+	return server
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// record adds the skew for an event from the given server, and returns the current average
+func (tc *trustChecker) record(server string, skew time.Duration) time.Duration {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	ss, ok := tc.skews[server]
+	if !ok {
+		ss = &serverSkew{}
+		tc.skews[server] = ss
+	}
+	ss.add(skew)
+	return ss.avg
+}
+
+// check measures the skew between the time an event was sent according to the sender's server,
+// and the time we received it, and decides if the timestamp can be used for scoring.
+// Timestamps from trusted servers are never rejected, but flagged if the skew is too large.
+// Senders without a server, as on IRC, are on the network we're connected to, which gives the timestamps,
+// or they are our own, so they are trusted like our own server.
+func (tc *trustChecker) check(logger *zerolog.Logger, sender string, sent, received time.Time) trustResult {
+	if tc == nil {
+		return trustResult{}
+	}
+
+	server := serverOf(sender)
+	skew := received.Sub(sent)
+	avg := tc.record(server, skew)
+
+	if tc.maxSkew <= 0 || absDuration(skew) <= tc.maxSkew {
+		return trustResult{}
+	}
+
+	trusted := tc.trusted[server] || server == ""
+	where := "for " + server
+	if server == "" {
+		where = "on this network"
+	}
+	logger.Warn().
+		Str("server", server).
+		Str("sender", sender).
+		Bool("trusted", trusted).
+		Dur("skew", skew).
+		Dur("avg_skew", avg).
+		Msg("Timestamp skew above threshold")
+
+	if trusted {
+		return trustResult{
+			verdict: trustFlagged,
+			reason: fmt.Sprintf(
+				"your entry reached me %s off from its timestamp (usually %s %s), so the result might be inaccurate",
+				skew.Round(time.Millisecond),
+				avg.Round(time.Millisecond),
+				where,
+			),
+		}
+	}
+
+	return trustResult{
+		verdict: trustRejected,
+		reason: fmt.Sprintf(
+			"your entry reached me %s off from its timestamp (usually %s %s), and max %s is allowed for untrusted servers",
+			skew.Round(time.Millisecond),
+			avg.Round(time.Millisecond),
+			where,
+			tc.maxSkew,
+		),
+	}
+}
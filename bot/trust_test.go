@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_serverOf(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "test.com", serverOf("@user:test.com"))
+	assert.Equal(t, "test.com:8448", serverOf("@user:test.com:8448"))
+	assert.Empty(t, serverOf("user"))
+}
+
+func Test_serverSkew_add(t *testing.T) {
+	t.Parallel()
+
+	ss := serverSkew{}
+	ss.add(time.Second)
+	assert.Equal(t, time.Second, ss.avg)
+	ss.add(2 * time.Second)
+	assert.Equal(t, 2*time.Second, ss.last)
+	assert.Equal(t, 1200*time.Millisecond, ss.avg)
+	assert.Equal(t, 2, ss.samples)
+}
+
+func Test_trustChecker_check(t *testing.T) {
+	t.Parallel()
+
+	logger := zerolog.Nop()
+	now := time.Date(2025, 5, 12, 13, 37, 0, 0, time.UTC)
+
+	assert.Equal(t, trustOK, (*trustChecker)(nil).check(&logger, "@user:test.com", now, now).verdict)
+
+	tc := newTrustChecker(
+		TrustPolicy{
+			TrustedServers: []string{"friend.com"},
+			MaxSkew:        time.Second,
+		},
+		"bot.com",
+	)
+
+	assert.Equal(t, trustOK, tc.check(&logger, "@user:other.com", now.Add(-time.Second), now).verdict)
+	assert.Equal(t, trustOK, tc.check(&logger, "@user:bot.com", now.Add(-time.Second), now).verdict)
+
+	res := tc.check(&logger, "@user:bot.com", now.Add(-2*time.Second), now)
+	assert.Equal(t, trustFlagged, res.verdict)
+	assert.NotEmpty(t, res.reason)
+	assert.Equal(t, trustFlagged, tc.check(&logger, "@user:friend.com", now.Add(-2*time.Second), now).verdict)
+
+	// timestamps from the future are just as suspicious
+	res = tc.check(&logger, "@user:other.com", now.Add(2*time.Second), now)
+	assert.Equal(t, trustRejected, res.verdict)
+	assert.Contains(t, res.reason, "other.com")
+
+	// IRC nicks have no server, but come through the network we're connected to
+	res = tc.check(&logger, "alice", now.Add(-2*time.Second), now)
+	assert.Equal(t, trustFlagged, res.verdict)
+	assert.Contains(t, res.reason, "(usually 2s on this network)")
+	assert.Equal(t, trustOK, tc.check(&logger, "alice", now.Add(-time.Second), now).verdict)
+
+	// disabled
+	tc = newTrustChecker(TrustPolicy{}, "bot.com")
+	assert.Equal(t, trustOK, tc.check(&logger, "@user:other.com", now.Add(-time.Hour), now).verdict)
+	assert.Equal(t, 1, tc.skews["other.com"].samples)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/oddlid/leetbot_matrix/leet"
+	"github.com/oddlid/leetbot_matrix/util"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+	"maunium.net/go/mautrix/id"
+)
+
+func openStore(cCtx *cli.Context) (*leet.Store, error) {
+	return leet.OpenStore(cCtx.Context, cCtx.Path(optDB))
+}
+
+func storedGame(cCtx *cli.Context, store *leet.Store, logger zerolog.Logger) *leet.Leet {
+	return leet.New(
+		logger,
+		leet.Config{
+			Store:      store,
+			Key:        cCtx.String(optGame),
+			ConfigFile: cCtx.Path(optConfigFile),
+			Backups:    cCtx.Int(optBackups),
+		},
+	)
+}
+
+// requireGame returns an error listing the known games, if the given game is not in the store
+func requireGame(ctx context.Context, store *leet.Store, game string) error {
+	games, err := store.Games(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(games, game) {
+		return fmt.Errorf("no game %q in database, available games: %v", game, games)
+	}
+	return nil
+}
+
+func exportEntryPoint(cCtx *cli.Context) error {
+	l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	store, err := openStore(cCtx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	if err = requireGame(cCtx.Context, store, cCtx.String(optGame)); err != nil {
+		return err
+	}
+
+	game := storedGame(cCtx, store, l)
+	if err = game.Load(cCtx.Context); err != nil {
+		return err
+	}
+	if err = game.SaveConfigFile(); err != nil {
+		return err
+	}
+	l.Info().Str("game", cCtx.String(optGame)).Str("config_file", cCtx.Path(optConfigFile)).Msg("Exported game")
+	return nil
+}
+
+func importEntryPoint(cCtx *cli.Context) error {
+	l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	store, err := openStore(cCtx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	game := storedGame(cCtx, store, l)
+	if err = game.LoadConfigFile(); err != nil {
+		return err
+	}
+	if err = game.Save(cCtx.Context); err != nil {
+		return err
+	}
+	l.Info().Str("game", cCtx.String(optGame)).Str("config_file", cCtx.Path(optConfigFile)).Msg("Imported game")
+	return nil
+}
+
+// readUserMapping reads a JSON object mapping IRC nicks to Matrix user IDs
+func readUserMapping(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var users map[string]string
+	if err = json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for nick, userID := range users {
+		if _, _, err = id.UserID(userID).Parse(); err != nil {
+			return nil, fmt.Errorf("%s: invalid user ID for %s: %w", path, nick, err)
+		}
+	}
+	return users, nil
+}
+
+func importLegacyEntryPoint(cCtx *cli.Context) error {
+	l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	users, err := readUserMapping(cCtx.Path(optUserMapping))
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(cCtx.Path(optLegacyFile))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	store, err := openStore(cCtx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	game := storedGame(cCtx, store, l)
+	if err = game.SetRoom(cCtx.String(optRoom)); err != nil {
+		return err
+	}
+	report, err := game.ImportLegacy(file, cCtx.String(optChannel), users)
+	if err != nil {
+		return err
+	}
+	if err = report.Write(os.Stdout); err != nil {
+		return err
+	}
+	if err = game.Save(cCtx.Context); err != nil {
+		return err
+	}
+	l.Info().Str("game", cCtx.String(optGame)).Str("legacy_file", cCtx.Path(optLegacyFile)).Msg("Imported legacy game")
+	return nil
+}
+
+func restoreEntryPoint(cCtx *cli.Context) error {
+	l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	if !cCtx.IsSet(optBackup) {
+		backups, err := leet.New(l, leet.Config{ConfigFile: cCtx.Path(optConfigFile)}).Backups()
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return util.Fpf(os.Stdout, "No backups of %s\n", cCtx.Path(optConfigFile))
+		}
+		for _, backup := range backups {
+			if err = util.Fpf(os.Stdout, "%s\n", backup); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var store *leet.Store
+	if cCtx.IsSet(optGame) {
+		var err error
+		if store, err = openStore(cCtx); err != nil {
+			return err
+		}
+		defer func() { _ = store.Close() }()
+	}
+
+	game := storedGame(cCtx, store, l)
+	if err := game.RestoreBackup(cCtx.String(optBackup)); err != nil {
+		return err
+	}
+	l.Info().Str("backup", cCtx.String(optBackup)).Str("config_file", cCtx.Path(optConfigFile)).Msg("Restored config file")
+	if store == nil {
+		return nil
+	}
+	if err := game.Save(cCtx.Context); err != nil {
+		return err
+	}
+	l.Info().Str("game", cCtx.String(optGame)).Msg("Imported restored config file")
+	return nil
+}
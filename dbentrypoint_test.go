@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oddlid/leetbot_matrix/leet"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPaths are the database and config file for a test, in their own dir
+type testPaths struct {
+	dir    string
+	db     string
+	config string
+}
+
+func newTestPaths(t *testing.T) testPaths {
+	t.Helper()
+	dir := t.TempDir()
+	return testPaths{dir: dir, db: filepath.Join(dir, "leet.db"), config: filepath.Join(dir, "config.json")}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+}
+
+// run runs the app with the database and config file of p, and the given subcommand and its flags
+func (p testPaths) run(t *testing.T, args ...string) {
+	t.Helper()
+	require.NoError(t, app().RunContext(context.Background(), append([]string{appName, "--db", p.db, "--config", p.config}, args...)))
+}
+
+// storedUsers returns the names of the users of the game in the database, ranked by points
+func (p testPaths) storedUsers(t *testing.T, game string) []string {
+	t.Helper()
+	ctx := context.Background()
+	store, err := leet.OpenStore(ctx, p.db)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+	l := leet.New(zerolog.Nop(), leet.Config{Store: store, Key: game})
+	defer l.Close()
+	require.NoError(t, l.Load(ctx))
+	stats, err := l.Stats()
+	require.NoError(t, err)
+	var names []string
+	for _, row := range stats.Rows {
+		names = append(names, row.Name)
+	}
+	return names
+}
+
+const twoUsersConfig = `{"users": {"users": {
+	"@alice:test.com": {"name": "@alice:test.com", "scores": {"times": 1, "total": 20}},
+	"@bob:test.com": {"name": "@bob:test.com", "scores": {"times": 1, "total": 10}}
+}}}`
+
+func Test_importLegacyEntryPoint_replacesGame(t *testing.T) {
+	t.Parallel()
+
+	p := newTestPaths(t)
+	writeFile(t, p.config, twoUsersConfig)
+	p.run(t, "import", "--game", "game")
+	require.Equal(t, []string{"@alice:test.com", "@bob:test.com"}, p.storedUsers(t, "game"))
+
+	legacy := filepath.Join(p.dir, "1337.json")
+	writeFile(t, legacy, `{"channels": {"#leet": {"users": {"Odd": {"nick": "Odd", "points": 900}}}}}`)
+	users := filepath.Join(p.dir, "users.json")
+	writeFile(t, users, `{"Odd": "@odd:test.com"}`)
+	p.run(t, "import-legacy", "--game", "game", "--legacy-file", legacy, "--users", users)
+
+	assert.Equal(t, []string{"@odd:test.com"}, p.storedUsers(t, "game"))
+}
+
+func Test_restoreEntryPoint_replacesGame(t *testing.T) {
+	t.Parallel()
+
+	p := newTestPaths(t)
+	const backup = "config.json.20250101T133700.000000000Z.bak"
+	writeFile(t, filepath.Join(p.dir, backup), `{"users": {"users": {"@alice:test.com": {"name": "@alice:test.com"}}}}`)
+	// bob joined after the backup was taken
+	writeFile(t, p.config, twoUsersConfig)
+	p.run(t, "import", "--game", "game")
+	require.Equal(t, []string{"@alice:test.com", "@bob:test.com"}, p.storedUsers(t, "game"))
+
+	p.run(t, "restore", "--backup", backup, "--game", "game")
+
+	assert.Equal(t, []string{"@alice:test.com"}, p.storedUsers(t, "game"))
+}
@@ -1,32 +1,201 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/oddlid/leetbot_matrix/bot"
+	"github.com/oddlid/leetbot_matrix/leet"
 	"github.com/oddlid/leetbot_matrix/ltime"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 )
 
+// transport returns the transports given, all with the clock of the bot, so that they agree on the time
+func transport(cCtx *cli.Context, l zerolog.Logger, clock ltime.Clock) (bot.Transport, error) {
+	var transports []bot.Transport
+	for _, name := range cCtx.StringSlice(optTransport) {
+		switch name {
+		case transportMatrix:
+			transports = append(
+				transports,
+				bot.NewMatrixTransport(
+					bot.MatrixConfig{
+						Username:      cCtx.String(optUser),
+						Password:      cCtx.String(optPass),
+						Server:        cCtx.String(optServer),
+						DBPath:        cCtx.Path(optDB),
+						HomeserverURL: cCtx.String(optHomeserverURL),
+						Clock:         clock,
+					},
+					l,
+				),
+			)
+		case transportIRC:
+			transports = append(
+				transports,
+				bot.NewIRCTransport(
+					bot.IRCConfig{
+						Server:   cCtx.String(optIRCServer),
+						TLS:      cCtx.Bool(optIRCTLS),
+						Nick:     cCtx.String(optIRCNick),
+						Password: cCtx.String(optIRCPass),
+						SASL:     cCtx.Bool(optIRCSASL),
+						Channels: cCtx.StringSlice(optIRCChannel),
+						Clock:    clock,
+					},
+					l,
+				),
+			)
+		case transportConsole:
+			player := os.Getenv("USER")
+			if player == "" {
+				player = defaultPlayer
+			}
+			transports = append(transports, bot.NewConsoleTransport(os.Stdin, os.Stdout, player, clock))
+		default:
+			return nil, fmt.Errorf("unknown transport: %q", name)
+		}
+	}
+	switch len(transports) {
+	case 0:
+		return nil, errors.New("no transport given")
+	case 1:
+		return transports[0], nil
+	default:
+		return bot.NewMultiTransport(transports...), nil
+	}
+}
+
+func timeFrame(cCtx *cli.Context) (ltime.TimeFrame, error) {
+	loc, err := time.LoadLocation(cCtx.String(optTimeZone))
+	if err != nil {
+		return ltime.TimeFrame{}, err
+	}
+	tf := ltime.TimeFrame{
+		Hour:         uint8(cCtx.Int(optHour)),
+		Minute:       uint8(cCtx.Int(optMinute)),
+		WindowBefore: cCtx.Duration(optEarly),
+		OnTime:       cCtx.Duration(optOnTime),
+		WindowAfter:  cCtx.Duration(optLate),
+		Location:     loc,
+	}
+	if tf.WindowBefore < 0 || tf.OnTime <= 0 || tf.WindowAfter < 0 {
+		return ltime.TimeFrame{}, errors.New("the early and late windows can't be negative, and the on time period must be positive")
+	}
+	if tf.WindowBefore+tf.OnTime+tf.WindowAfter >= 24*time.Hour {
+		return ltime.TimeFrame{}, errors.New("the entry window must be shorter than a day")
+	}
+	return tf, nil
+}
+
+// extraGames returns the games to play besides the main one, each given as "name=HH:MM",
+// and played with the same windows and time zone as the main game
+func extraGames(cCtx *cli.Context, tf ltime.TimeFrame) ([]bot.GameConfig, error) {
+	var games []bot.GameConfig
+	for _, s := range cCtx.StringSlice(optExtraGame) {
+		name, at, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid game %q, expected name=HH:MM", s)
+		}
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time for game %q: %w", name, err)
+		}
+		gtf := tf
+		gtf.Hour = uint8(t.Hour())
+		gtf.Minute = uint8(t.Minute())
+		games = append(games, bot.GameConfig{Name: name, TimeFrame: gtf})
+	}
+	return games, nil
+}
+
 func botEntryPoint(cCtx *cli.Context) error {
-	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	out := os.Stdout
+	if slices.Contains(cCtx.StringSlice(optTransport), transportConsole) {
+		// stdout is for playing
+		out = os.Stderr
+	}
+	l := zerolog.New(out).With().Timestamp().Logger()
+	tf, err := timeFrame(cCtx)
+	if err != nil {
+		return err
+	}
+	games, err := extraGames(cCtx, tf)
+	if err != nil {
+		return err
+	}
 	cfg := bot.BotConfig{
-		Username:   cCtx.String(optUser),
-		Password:   cCtx.String(optPass),
-		Server:     cCtx.String(optServer),
-		Room:       cCtx.String(optRoom),
-		DBPath:     cCtx.Path(optDB),
-		ConfigFile: cCtx.Path(optConfigFile),
-		TimeFrame: ltime.TimeFrame{
-			Hour:   uint8(cCtx.Int(optHour)),
-			Minute: uint8(cCtx.Int(optMinute)),
-			// We currently hard code these, since it's unlikely we'll start this game over with differenct values,
-			// but at least it would be easy to add support for other time windows
-			WindowBefore: time.Minute,
-			WindowAfter:  time.Minute,
+		Server:      cCtx.String(optServer),
+		Room:        cCtx.String(optRoom),
+		DBPath:      cCtx.Path(optDB),
+		ConfigFile:  cCtx.Path(optConfigFile),
+		TimeFrame:   tf,
+		Games:       games,
+		GracePeriod: cCtx.Duration(optGrace),
+		Trust: bot.TrustPolicy{
+			TrustedServers: cCtx.StringSlice(optTrustedServers),
+			MaxSkew:        cCtx.Duration(optMaxSkew),
+		},
+		SharedLeaderboard: cCtx.Bool(optShared),
+		Feedback:          bot.Feedback(cCtx.String(optFeedback)),
+		Backlog:           bot.Backlog(cCtx.String(optBacklog)),
+		Clock:             ltime.RealClock,
+	}
+	if cfg.Feedback != bot.FeedbackReply && cfg.Feedback != bot.FeedbackReact {
+		return fmt.Errorf("unknown feedback: %q", cfg.Feedback)
+	}
+	if cfg.Backlog != bot.BacklogDiscard && cfg.Backlog != bot.BacklogScore {
+		return fmt.Errorf("unknown backlog: %q", cfg.Backlog)
+	}
+	t, err := transport(cCtx, l, cfg.Clock)
+	if err != nil {
+		return err
+	}
+	return bot.New(cfg, t, l).Start(cCtx.Context)
+}
+
+// simulateEntryPoint replays entries from a file against the game in the config file, without saving anything
+func simulateEntryPoint(cCtx *cli.Context) error {
+	l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	in := os.Stdin
+	if path := cCtx.Path(optEntries); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		in = file
+	}
+	entries, err := leet.ReadSimEntries(in)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cCtx.Path(optEntries), err)
+	}
+
+	tf, err := timeFrame(cCtx)
+	if err != nil {
+		return err
+	}
+
+	// a fresh game starts with the first entry
+	first := slices.MinFunc(entries, func(a, b leet.SimEntry) int { return a.Timestamp.Compare(b.Timestamp) })
+	game := leet.New(
+		l,
+		leet.Config{
+			ConfigFile: cCtx.Path(optConfigFile),
+			TimeFrame:  tf,
+			Clock:      ltime.NewFakeClock(first.Timestamp),
 		},
+	)
+	defer game.Close()
+	if !cCtx.Bool(optFresh) {
+		if err = game.LoadConfigFile(); err != nil {
+			return err
+		}
 	}
-	return bot.New(cfg, l).Start(cCtx.Context)
+	return game.Simulate(cCtx.Context, os.Stdout, entries, cCtx.Bool(optVerbose))
 }
@@ -0,0 +1,213 @@
+package leet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupTimeLayout = `20060102T150405.000000000Z` // sorts in time order
+	backupExt        = `.bak`
+)
+
+var ErrConfigMismatch = errors.New("written config file does not match the game state")
+
+// marshalConfig returns db in the format used for the JSON config file
+func marshalConfig(db *DB) ([]byte, error) {
+	data, err := json.MarshalIndent(db, "", "  ") // save in pretty format, to make it easier to update config by hand
+	if err != nil {
+		return nil, err
+	}
+	// add newline
+	return append(data, '\n'), nil
+}
+
+// verifyConfigFile checks that the file at path decodes to the same state as data
+func verifyConfigFile(path string, data []byte) error {
+	written, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var db DB
+	if err = json.Unmarshal(written, &db); err != nil {
+		return errors.Join(ErrConfigMismatch, err)
+	}
+	again, err := marshalConfig(&db)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(again, data) {
+		return ErrConfigMismatch
+	}
+	return nil
+}
+
+func backupPath(path string, t time.Time) string {
+	return path + "." + t.UTC().Format(backupTimeLayout) + backupExt
+}
+
+// configBackups returns the backups of the config file at path, newest first
+func configBackups(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base+".") || !strings.HasSuffix(name, backupExt) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, base+"."), backupExt)
+		if _, err = time.Parse(backupTimeLayout, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, name))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// copyFile copies src to dst, which must not exist
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// backupConfigFile keeps a copy of the current config file, if any, named by the time it was taken,
+// and removes the oldest backups, so that at most keep are left
+func backupConfigFile(path string, keep int, now time.Time) error {
+	if keep <= 0 {
+		return nil
+	}
+	if err := copyFile(path, backupPath(path, now)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	backups, err := configBackups(path)
+	if err != nil {
+		return err
+	}
+	for _, old := range backups[min(keep, len(backups)):] {
+		if err = os.Remove(old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir makes sure a rename in dir is persisted
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+	return d.Sync()
+}
+
+// writeConfigFile replaces the config file at path with data, without ever leaving a partly written file behind.
+// Data is written to a temp file, which is synced and verified before it's renamed to path.
+// The previous file is kept as a backup taken now, if keep > 0.
+func writeConfigFile(path string, data []byte, keep int, now time.Time) (err error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if info, statErr := os.Stat(path); statErr == nil {
+		if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = verifyConfigFile(tmp.Name(), data); err != nil {
+		return err
+	}
+	if err = backupConfigFile(path, keep, now); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Backups returns the backups of the config file, newest first
+func (l *Leet) Backups() ([]string, error) {
+	if l == nil {
+		return nil, ErrNilReceiver
+	}
+	if l.configFilePath == "" {
+		return nil, ErrNoConfigFile
+	}
+	return configBackups(l.configFilePath)
+}
+
+// RestoreBackup rolls the config file back to the given backup, and loads it.
+// The backup can be given by full path, or by file name only, if it's next to the config file.
+// The config file being replaced is backed up as well, so a restore can be undone.
+func (l *Leet) RestoreBackup(backup string) error {
+	if l == nil {
+		return ErrNilReceiver
+	}
+	if l.configFilePath == "" {
+		return ErrNoConfigFile
+	}
+	if filepath.Base(backup) == backup {
+		backup = filepath.Join(filepath.Dir(l.configFilePath), backup)
+	}
+	file, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer l.logErrFn(file.Close)
+	if err = l.loadConfig(file); err != nil {
+		return err
+	}
+	return l.SaveConfigFile()
+}
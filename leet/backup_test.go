@@ -0,0 +1,79 @@
+package leet
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeConfigFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	now := time.Date(2025, 5, 12, 13, 37, 0, 0, time.UTC)
+
+	assert.ErrorIs(t, writeConfigFile(path, []byte("{\n"), 2, now), ErrConfigMismatch)
+	_, err := os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist, "nothing should be written when verification fails")
+
+	for i := range 4 {
+		db := DB{Room: string(rune('a' + i))}
+		data, err := marshalConfig(&db)
+		require.NoError(t, err)
+		require.NoError(t, writeConfigFile(path, data, 2, now.Add(time.Duration(i)*time.Second)))
+	}
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "only the config file and backups should be left")
+
+	backups, err := configBackups(path)
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	data, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"room": "c"`)
+	data, err = os.ReadFile(backups[1])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"room": "b"`)
+	// named by when they were taken
+	assert.Equal(t, path+".20250512T133703.000000000Z.bak", backups[0])
+}
+
+func Test_Leet_RestoreBackup(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	l := New(zerolog.Nop(), Config{ConfigFile: path, Backups: 3})
+	l.db.Users.getUser("user").Scores.Add(5)
+	require.NoError(t, l.SaveConfigFile())
+	l.db.Users.getUser("user").Scores.Add(5)
+	require.NoError(t, l.SaveConfigFile())
+
+	backups, err := l.Backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+
+	l = New(zerolog.Nop(), Config{ConfigFile: path, Backups: 3})
+	require.NoError(t, l.RestoreBackup(filepath.Base(backups[0])))
+	assert.Equal(t, 5, l.db.Users.getUser("user").Scores.Total)
+
+	l = New(zerolog.Nop(), Config{ConfigFile: path})
+	require.NoError(t, l.LoadConfigFile())
+	assert.Equal(t, 5, l.db.Users.getUser("user").Scores.Total)
+
+	// the replaced version should be kept, so that the restore can be undone
+	backups, err = l.Backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	require.NoError(t, l.RestoreBackup(backups[0]))
+	assert.Equal(t, 10, l.db.Users.getUser("user").Scores.Total)
+
+	assert.ErrorIs(t, New(zerolog.Nop(), Config{}).RestoreBackup("x"), ErrNoConfigFile)
+}
@@ -3,9 +3,11 @@ package leet
 import (
 	"context"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/oddlid/leetbot_matrix/util"
 )
 
 type LeetConfig struct {
@@ -23,6 +25,145 @@ type DB struct {
 	BotStart  time.Time    `json:"botstart"`
 }
 
-func (db *DB) handleEntry(_ context.Context, w io.Writer, user *User, tfr ltime.TimeFrameResult) {
-	//
+// replace sets all values from other, which should not be used afterwards
+func (db *DB) replace(other *DB) {
+	db.Room = other.Room
+	db.BonusCfgs = other.BonusCfgs
+	db.GameCfg = other.GameCfg
+	db.BotStart = other.BotStart
+	db.Users.mu.Lock()
+	db.Users.Users = other.Users.Users
+	db.Users.mu.Unlock()
+}
+
+// clone returns a deep copy of db
+func (db *DB) clone() *DB {
+	c := &DB{
+		Room:      db.Room,
+		BonusCfgs: append(BonusConfigs(nil), db.BonusCfgs...),
+		GameCfg:   db.GameCfg,
+		BotStart:  db.BotStart,
+	}
+	db.Users.mu.RLock()
+	defer db.Users.mu.RUnlock()
+	c.Users.Users = make(map[string]*User, len(db.Users.Users))
+	for name, u := range db.Users.Users {
+		c.Users.Users[name] = u.clone()
+	}
+	return c
+}
+
+// scoreForTimeStamp returns the points for the given sub-second timestamp string,
+// which is the position of the first non-zero digit, counting from 1.
+// So, the closer to the target time, the more points:
+// 59xxxxxxxxx = 1 point
+// 05xxxxxxxxx = 2 points
+// 001xxxxxxxx = 3 points
+// ...
+// 00000000000 = 11 points
+func scoreForTimeStamp(ts string) int {
+	pos := strings.IndexFunc(ts, func(r rune) bool { return r != '0' })
+	if pos == -1 {
+		return len(ts)
+	}
+	return pos + 1
+}
+
+func subSecondString(t time.Time) string {
+	var sb strings.Builder
+	_ = ltime.FormatTimeStampSubSecond(&sb, t) // writing to a strings.Builder never fails
+	return sb.String()
+}
+
+// offsetString is like subSecondString, but for how long after the target an entry on time was.
+// For the target minute, that's the same, but it keeps entries later in a longer on time period
+// from scoring as if they were right on target.
+func offsetString(tfr ltime.TimeFrameResult) string {
+	var sb strings.Builder
+	_ = ltime.FormatOffsetSubSecond(&sb, tfr.Offset) // writing to a strings.Builder never fails
+	return sb.String()
+}
+
+// missedByString is like offsetString, but for how far outside the on time period a miss was,
+// whether early or late, so that the closest misses cost the most.
+func missedByString(tfr ltime.TimeFrameResult) string {
+	var sb strings.Builder
+	_ = ltime.FormatOffsetSubSecond(&sb, tfr.MissedBy()) // writing to a strings.Builder never fails
+	return sb.String()
+}
+
+// handleEntry does the scoring for a user that is allowed to play in this round,
+// and returns what was given or taken, for the round summary.
+// The caller is responsible for checking that the entry is inside the time window,
+// and that the user is not done or locked.
+func (db *DB) handleEntry(_ context.Context, w io.Writer, user *User, tfr ltime.TimeFrameResult) (roundEntry, error) {
+	// only one entry per round, no matter how it went
+	user.locked.Store(true)
+	user.Entries.Update(tfr.TF, tfr.TS)
+
+	entry := roundEntry{
+		user: user,
+		tfr:  tfr,
+	}
+
+	if err := ltime.FormatTimeStampFull(w, tfr.TS); err != nil {
+		return entry, err
+	}
+
+	var err error
+	if tfr.Code.NearMiss() {
+		entry.points, err = db.handleMiss(w, user, tfr)
+	} else {
+		entry.points, entry.bonus, err = db.handleScore(w, user, tfr)
+	}
+	return entry, err
+}
+
+// handleMiss subtracts points by how close the entry came to being on time, as if it had been that far from the
+// target, but never so much that the total goes below zero.
+// It returns the (negative) points given.
+func (db *DB) handleMiss(w io.Writer, user *User, tfr ltime.TimeFrameResult) (int, error) {
+	penalty := min(scoreForTimeStamp(missedByString(tfr)), user.Scores.Total)
+	user.Missees.Add(penalty)
+	user.Scores.Add(-penalty)
+
+	return -penalty, util.Fpf(
+		w,
+		": %s - Too %s by %s! -%d points. Total: %d",
+		user.Name,
+		tfr.Code.String(),
+		tfr.MissedBy().String(),
+		penalty,
+		user.Scores.Total,
+	)
+}
+
+// handleScore gives points and bonus for an entry on time.
+// It returns the total points given, and how much of that was bonus.
+func (db *DB) handleScore(w io.Writer, user *User, tfr ltime.TimeFrameResult) (int, int, error) {
+	ts := offsetString(tfr)
+	points := scoreForTimeStamp(ts)
+	brs := db.BonusCfgs.calc(ts)
+	bonus := brs.totalBonus()
+
+	user.Bonuses.Add(bonus)
+	user.Scores.Add(points + bonus)
+
+	total := points + bonus
+	if err := util.Fpf(w, ": %s - +%d points", user.Name, points); err != nil {
+		return total, bonus, err
+	}
+	if bonus > 0 {
+		if err := util.Fpf(w, ", "); err != nil {
+			return total, bonus, err
+		}
+		if err := brs.printBonus(w); err != nil {
+			return total, bonus, err
+		}
+	}
+	if err := util.Fpf(w, ". Total: %d", user.Scores.Total); err != nil {
+		return total, bonus, err
+	}
+	// Whether the user is done or not, is decided after taxes at the end of the round
+	return total, bonus, db.BonusCfgs.greetForPoints(w, user.Scores.Total)
 }
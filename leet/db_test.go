@@ -0,0 +1,113 @@
+package leet
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTimeFrame() ltime.TimeFrame {
+	return ltime.TimeFrame{
+		Hour:         13,
+		Minute:       37,
+		WindowBefore: time.Minute,
+		WindowAfter:  time.Minute,
+	}
+}
+
+func Test_scoreForTimeStamp(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 1, scoreForTimeStamp("59123456789"))
+	assert.Equal(t, 2, scoreForTimeStamp("05123456789"))
+	assert.Equal(t, 3, scoreForTimeStamp("00123456789"))
+	assert.Equal(t, 11, scoreForTimeStamp("00000000000"))
+	assert.Equal(t, 0, scoreForTimeStamp(""))
+}
+
+func Test_DB_handleEntry_onTime(t *testing.T) {
+	t.Parallel()
+
+	db := DB{
+		BonusCfgs: BonusConfigs{
+			{
+				Greeting:     "Nice!",
+				SubVal:       1337,
+				StepPoints:   10,
+				NoStepPoints: 5,
+				PrefixChar:   '0',
+				UseStep:      true,
+			},
+		},
+	}
+	u := User{Name: "user"}
+	tf := testTimeFrame()
+	ts := time.Date(2025, 5, 12, 13, 37, 0, 13370000, time.UTC) // 00013370000
+
+	var buf strings.Builder
+	entry, err := db.handleEntry(context.Background(), &buf, &u, tf.Code(ts))
+	require.NoError(t, err)
+	t.Log(buf.String())
+
+	assert.True(t, u.locked.Load())
+	assert.True(t, u.Entries.Last.Equal(ts))
+	assert.Equal(t, 40, u.Bonuses.Total) // match at position 3, so (3+1) * 10
+	assert.Equal(t, 4+40, u.Scores.Total)
+	assert.Equal(t, 1, u.Scores.Times)
+	assert.False(t, u.Done)
+	assert.Equal(t, 44, entry.points)
+	assert.Equal(t, 40, entry.bonus)
+}
+
+func Test_DB_handleEntry_longOnTime(t *testing.T) {
+	t.Parallel()
+
+	tf := testTimeFrame()
+	tf.OnTime = 2 * time.Minute
+	for ts, points := range map[time.Time]int{
+		time.Date(2025, 5, 12, 13, 37, 0, 100000000, time.UTC): 3,
+		time.Date(2025, 5, 12, 13, 38, 0, 100000000, time.UTC): 1, // not as good as 13:37:00.1
+		time.Date(2025, 5, 12, 13, 38, 5, 0, time.UTC):         1,
+	} {
+		u := User{Name: "user"}
+		var buf strings.Builder
+		entry, err := (&DB{}).handleEntry(context.Background(), &buf, &u, tf.Code(ts))
+		require.NoError(t, err)
+		assert.Equal(t, points, entry.points, ts)
+	}
+}
+
+func Test_DB_handleEntry_miss(t *testing.T) {
+	t.Parallel()
+
+	tf := testTimeFrame()
+	for ts, penalty := range map[time.Time]int{
+		time.Date(2025, 5, 12, 13, 36, 59, 999000000, time.UTC): 5, // early by 00001000000
+		time.Date(2025, 5, 12, 13, 36, 59, 500000000, time.UTC): 3, // early by 00500000000
+		time.Date(2025, 5, 12, 13, 36, 0, 100000000, time.UTC):  1, // early by 59900000000
+		time.Date(2025, 5, 12, 13, 38, 0, 1000000, time.UTC):    5, // late by 00001000000
+		time.Date(2025, 5, 12, 13, 38, 0, 100000000, time.UTC):  3, // late by 00100000000
+		time.Date(2025, 5, 12, 13, 38, 59, 0, time.UTC):         1, // late by 59000000000
+	} {
+		u := User{Name: "user", Scores: ValueTracker{Total: 10}}
+		var buf strings.Builder
+		entry, err := (&DB{}).handleEntry(context.Background(), &buf, &u, tf.Code(ts))
+		require.NoError(t, err)
+		assert.Equal(t, -penalty, entry.points, ts)
+		assert.Equal(t, 10-penalty, u.Scores.Total, ts)
+		assert.Equal(t, penalty, u.Missees.Total, ts)
+	}
+
+	// never below zero
+	u := User{Name: "user", Scores: ValueTracker{Total: 1}}
+	var buf strings.Builder
+	_, err := (&DB{}).handleEntry(context.Background(), &buf, &u, tf.Code(time.Date(2025, 5, 12, 13, 38, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	assert.Equal(t, 0, u.Scores.Total)
+	assert.Equal(t, 1, u.Missees.Total)
+}
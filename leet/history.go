@@ -0,0 +1,136 @@
+package leet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/oddlid/leetbot_matrix/util"
+)
+
+// MaxHistory is the max number of rounds to show history for
+const MaxHistory = 20
+
+var ErrNoHistory = errors.New("history is only available with a database")
+
+// historyEntry is a stored entry from a previous round
+type historyEntry struct {
+	round  string
+	origin time.Time // unadjusted timestamp from the sender's server
+	ts     time.Time // adjusted timestamp used for scoring
+	code   ltime.TimeCode
+	offset time.Duration
+	points int
+	bonus  int
+	tax    int
+}
+
+// history returns the last n entries for the given user, newest first
+func (s *Store) history(ctx context.Context, game, user string, n int) ([]historyEntry, error) {
+	if s == nil {
+		return nil, ErrNilStore
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT round, origin_ts, ts, code, offset_ns, points, bonus, tax
+		FROM leet_entries WHERE game = ? AND user = ? ORDER BY ts DESC LIMIT ?`,
+		game,
+		user,
+		n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []historyEntry
+	for rows.Next() {
+		var (
+			he         historyEntry
+			origin, ts string
+		)
+		if err = rows.Scan(&he.round, &origin, &ts, &he.code, &he.offset, &he.points, &he.bonus, &he.tax); err != nil {
+			return nil, err
+		}
+		if he.origin, err = parseTime(origin); err != nil {
+			return nil, err
+		}
+		if he.ts, err = parseTime(ts); err != nil {
+			return nil, err
+		}
+		entries = append(entries, he)
+	}
+	return entries, rows.Err()
+}
+
+func (he historyEntry) write(w io.Writer, tf ltime.TimeFrame) error {
+	if err := util.Fpf(w, "%s ", he.round); err != nil {
+		return err
+	}
+	if err := ltime.FormatTimeStampFull(w, he.ts); err != nil {
+		return err
+	}
+	if !he.origin.IsZero() {
+		if err := util.Fpf(w, " (sent "); err != nil {
+			return err
+		}
+		if err := ltime.FormatTimeStampFull(w, he.origin); err != nil {
+			return err
+		}
+		if err := util.Fpf(w, ")"); err != nil {
+			return err
+		}
+	}
+	if err := util.Fpf(w, " %s", he.code.String()); err != nil {
+		return err
+	}
+	if he.code != ltime.TCOnTime {
+		if err := util.Fpf(w, " by %s", ltime.TimeFrameResult{TF: tf, Code: he.code, Offset: he.offset}.MissedBy()); err != nil {
+			return err
+		}
+	}
+	if err := util.Fpf(w, ": %+d", he.points-he.bonus); err != nil {
+		return err
+	}
+	if he.bonus > 0 {
+		if err := util.Fpf(w, " +%d bonus", he.bonus); err != nil {
+			return err
+		}
+	}
+	if he.tax > 0 {
+		if err := util.Fpf(w, " -%d tax", he.tax); err != nil {
+			return err
+		}
+	}
+	return util.Fpf(w, "\n")
+}
+
+// History writes the given user's last n rounds to w, newest first
+func (l *Leet) History(ctx context.Context, w io.Writer, user string, n int) error {
+	if l == nil {
+		return ErrNilReceiver
+	}
+	if l.store == nil {
+		return ErrNoHistory
+	}
+
+	entries, err := l.store.history(ctx, l.key, user, min(n, MaxHistory))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return util.Fpf(w, "No history for %s", user)
+	}
+
+	if err = util.Fpf(w, "Last %d round(s) for %s:\n", len(entries), user); err != nil {
+		return err
+	}
+	for _, he := range entries {
+		if err = he.write(w, l.tf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
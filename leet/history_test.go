@@ -0,0 +1,57 @@
+package leet
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Leet_History(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var buf strings.Builder
+
+	assert.ErrorIs(t, (*Leet)(nil).History(ctx, &buf, "user", 1), ErrNilReceiver)
+	assert.ErrorIs(t, New(zerolog.Nop(), Config{}).History(ctx, &buf, "user", 1), ErrNoHistory)
+
+	tf := testTimeFrame()
+	l := New(zerolog.Nop(), Config{Store: testStore(t), Key: "game", TimeFrame: tf})
+	l.db.GameCfg = LeetConfig{InspectionTax: 50, TaxLoners: true}
+
+	assert.NoError(t, l.History(ctx, &buf, "user", 1))
+	assert.Equal(t, "No history for user", buf.String())
+
+	for day := 1; day <= 3; day++ {
+		l.OpenRound()
+		ts := time.Date(2025, 5, day, 13, 37, 0, 1000*day, time.UTC)
+		_, err := l.Play(ctx, &buf, "user", ts.Truncate(time.Millisecond), tf.Code(ts))
+		require.NoError(t, err)
+		_, err = l.CloseRound(ctx, ts)
+		require.NoError(t, err)
+	}
+	// a miss as well
+	l.OpenRound()
+	ts := time.Date(2025, 5, 4, 13, 38, 1, 0, time.UTC)
+	outcome, err := l.Play(ctx, &buf, "user", ts, tf.Code(ts))
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeMissed, outcome)
+	_, err = l.CloseRound(ctx, ts)
+	require.NoError(t, err)
+
+	buf.Reset()
+	require.NoError(t, l.History(ctx, &buf, "user", 3))
+	t.Log(buf.String())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "Last 3 round(s) for user:", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "2025-05-04 [13:38:01:000000000]"))
+	assert.Contains(t, lines[1], "late by 1s")
+	assert.True(t, strings.HasPrefix(lines[2], "2025-05-03 [13:37:00:000003000] (sent [13:37:00:000000000]) on time: +8"))
+	assert.Contains(t, lines[2], "tax")
+}
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -15,31 +17,65 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// Config is what's needed to set up a game
+type Config struct {
+	Store      *Store          // where the game state is persisted, if nil, only the JSON config file is used
+	Key        string          // identifies the game in the store
+	ConfigFile string          // JSON file for import and export of the game state
+	Room       string          // the room the game is played in
+	TimeFrame  ltime.TimeFrame // when the game is played
+	Backups    int             // how many backups of the config file to keep
+	Clock      ltime.Clock     // where the time comes from, if nil, the system clock is used
+}
+
+// Leet is one game. All changes to db, round and provisional are done on the event loop (see do),
+// while reads from other goroutines work on snapshots.
 type Leet struct {
+	store          *Store
+	key            string
 	configFilePath string
+	backups        int
 	db             DB
 	logger         zerolog.Logger
 	tf             ltime.TimeFrame
-	active         atomic.Bool // true when between the time of first score giving entry and round calculation done
+	clock          ltime.Clock
+	state          atomic.Uint32   // holds a RoundState
+	round          roundEntries    // entries in the current round
+	provisional    []string        // ranking from the provisional results, if any
+	roll           func(n int) int // random number in [0, n), for inspections. Uses math/rand if nil.
+	cmds           chan func()     // commands for the event loop
+	quit           chan struct{}   // closed to stop the event loop
+	fileMu         sync.Mutex      // serialises writes of the config file
+	loopOnce       sync.Once
+	closeOnce      sync.Once
 }
 
 var (
 	ErrNilReceiver  = errors.New("receiver is nil")
 	ErrNoConfigFile = errors.New("no config file path given")
+	ErrRoundBusy    = errors.New("round calculation in progress")
 )
 
-func New(logger zerolog.Logger, configFilePath, room string, tf ltime.TimeFrame) *Leet {
+func New(logger zerolog.Logger, cfg Config) *Leet {
 	return &Leet{
-		configFilePath: configFilePath,
-		logger:         logger.With().Str("module", "leet").Logger(),
-		tf:             tf,
+		store:          cfg.Store,
+		key:            cfg.Key,
+		configFilePath: cfg.ConfigFile,
+		backups:        cfg.Backups,
+		logger:         logger.With().Str("module", "leet").Str("game", cfg.Key).Logger(),
+		tf:             cfg.TimeFrame,
+		clock:          cfg.Clock,
 		db: DB{
-			BotStart: time.Now(), // will be overwritten on config load, but needs to be set on first run
-			Room:     room,
+			BotStart: ltime.ClockOrReal(cfg.Clock).Now(), // will be overwritten on config load, but needs to be set on first run
+			Room:     cfg.Room,
 		},
 	}
 }
 
+func (l *Leet) now() time.Time {
+	return ltime.ClockOrReal(l.clock).Now()
+}
+
 func (l *Leet) logErr(err error) {
 	if l == nil || err == nil {
 		return
@@ -51,27 +87,90 @@ func (l *Leet) logErrFn(f func() error) {
 	l.logErr(f())
 }
 
-func (l *Leet) Play(_ context.Context, w io.Writer, userName string, tfr ltime.TimeFrameResult) error {
+func (l *Leet) rollDice(n int) int {
+	if l.roll != nil {
+		return l.roll(n)
+	}
+	return rand.IntN(n)
+}
+
+// Outcome is what came of an entry
+type Outcome int
+
+const (
+	OutcomeScored Outcome = iota // on time, and given points
+	OutcomeMissed                // too early or too late, and lost points
+	OutcomeSpam                  // the player has already played in this round
+	OutcomeDone                  // the player has already reached the target
+	OutcomeClosed                // the round was already closed
+)
+
+// Play handles an entry from the given user, and tells the player how it went, both by writing to w, and by the
+// outcome returned. The origin is the unadjusted timestamp of the entry, as given by the sender's server,
+// which is kept for history.
+func (l *Leet) Play(ctx context.Context, w io.Writer, userName string, origin time.Time, tfr ltime.TimeFrameResult) (Outcome, error) {
 	if l == nil {
-		return ErrNilReceiver
+		return 0, ErrNilReceiver
+	}
+
+	var (
+		outcome Outcome
+		err     error
+	)
+	if doErr := l.do(func() { outcome, err = l.play(ctx, w, userName, origin, tfr) }); doErr != nil {
+		return 0, doErr
+	}
+	return outcome, err
+}
+
+// play does the work for Play, on the event loop.
+// The round state is checked here, and not before, so that an entry can't sneak in after the round is closed.
+func (l *Leet) play(ctx context.Context, w io.Writer, userName string, origin time.Time, tfr ltime.TimeFrameResult) (Outcome, error) {
+	if l.handleRoundOver(w, userName, tfr.TS) {
+		return OutcomeClosed, nil
 	}
-	// TODO:: make sure to restore this when the round is over and calculations are done
-	// l.active.Store(true)
 
 	user := l.db.Users.getUser(userName)
 	if user == nil {
-		return fmt.Errorf("no such user: %s", userName)
+		return 0, fmt.Errorf("no such user: %s", userName)
 	}
 
 	if l.handleFinishedPlayer(w, user, tfr.TS) {
-		return nil
+		return OutcomeDone, nil
 	}
 
 	if l.checkSpam(w, user, tfr.TS) {
-		return nil
+		return OutcomeSpam, nil
+	}
+
+	entry, err := l.db.handleEntry(ctx, w, user, tfr)
+	entry.origin = origin
+	l.round = append(l.round, entry)
+	if tfr.Code.NearMiss() {
+		return OutcomeMissed, err
+	}
+	return OutcomeScored, err
+}
+
+// handleRoundOver moves the round to collecting on the first entry, and tells the player
+// if the entry comes in after the round has been closed.
+func (l *Leet) handleRoundOver(w io.Writer, userName string, ts time.Time) bool {
+	for {
+		state := l.State()
+		switch state {
+		case RoundIdle, RoundOpen:
+			// The round might not have been opened if we started in the middle of it
+			if l.state.CompareAndSwap(uint32(state), uint32(RoundCollecting)) {
+				return false
+			}
+		case RoundCollecting, RoundProvisional:
+			return false
+		default:
+			l.logErr(ltime.FormatTimeStampFull(w, ts))
+			l.logErr(util.Fpf(w, ": %s - Sorry, this round is already closed.", userName))
+			return true
+		}
 	}
-	// ...
-	return nil
 }
 
 func (l *Leet) handleFinishedPlayer(w io.Writer, user *User, ts time.Time) bool {
@@ -110,83 +209,233 @@ func (l *Leet) SetRoom(id string) error {
 	if l == nil {
 		return ErrNilReceiver
 	}
-	// might need a lock on this
-	l.db.Room = id
-	return nil
+	return l.do(func() { l.db.Room = id })
 }
 
 func (l *Leet) GetRoom() (string, error) {
 	if l == nil {
 		return "", ErrNilReceiver
 	}
-	return l.db.Room, nil
+	var room string
+	err := l.do(func() { room = l.db.Room })
+	return room, err
 }
 
-func (l *Leet) Stats(w io.Writer) error {
+// TimeFrame returns when the game is played, which never changes, so there's no need to go through the event loop
+func (l *Leet) TimeFrame() ltime.TimeFrame {
 	if l == nil {
-		return ErrNilReceiver
+		return ltime.TimeFrame{}
 	}
+	return l.tf
+}
 
-	greet := func(points int) error {
-		has, bc := l.db.BonusCfgs.hasValue(points)
-		if !has {
-			return nil
+// Stats returns the current standings, from a snapshot, so that the game is not held up while rendering them
+func (l *Leet) Stats() (StatsReport, error) {
+	if l == nil {
+		return StatsReport{}, ErrNilReceiver
+	}
+	db, err := l.snapshot()
+	if err != nil {
+		return StatsReport{}, err
+	}
+	return db.statsReport(), nil
+}
+
+func (db *DB) statsReport() StatsReport {
+	winners := db.Users.filterByDone(true).sortByLastEntryAsc()
+	sr := StatsReport{Since: db.BotStart}
+	for _, u := range db.Users.toSlice().sortByPointsDesc() {
+		row := StatsRow{
+			Name:       u.Name,
+			Points:     u.Scores.Total,
+			Last:       u.Entries.Last,
+			Best:       u.Entries.Best,
+			BonusTimes: u.Bonuses.Times,
+			BonusTotal: u.Bonuses.Total,
+			TaxTimes:   u.Taxes.Times,
+			TaxTotal:   u.Taxes.Total,
+			Misses:     u.Missees.Total,
+		}
+		if u.Done {
+			row.Winner = winners.getIndex(u.Name) + 1
 		}
-		return util.Fpf(w, " - %s", bc.Greeting)
+		if has, bc := db.BonusCfgs.hasValue(u.Scores.Total); has {
+			row.Greeting = bc.Greeting
+		}
+		sr.Rows = append(sr.Rows, row)
+	}
+	return sr
+}
+
+// State returns the current state of the round
+func (l *Leet) State() RoundState {
+	if l == nil {
+		return RoundIdle
+	}
+	return RoundState(l.state.Load())
+}
+
+// Active returns true when between the time of first score giving entry and round calculation done
+func (l *Leet) Active() bool {
+	state := l.State()
+	return state == RoundCollecting || state == RoundProvisional || state == RoundCalculating
+}
+
+// Calculating returns true while the results of the round are being calculated
+func (l *Leet) Calculating() bool {
+	return l.State() == RoundCalculating
+}
+
+// OpenRound resets everything from the previous round, and opens for entries
+func (l *Leet) OpenRound() {
+	if l == nil {
+		return
+	}
+	err := l.do(func() {
+		l.round = nil
+		l.provisional = nil
+		l.db.Users.unlockAll()
+		l.state.Store(uint32(RoundOpen))
+	})
+	if err != nil {
+		l.logger.Error().Err(err).Msg("Failed to open round")
+		return
 	}
+	l.logger.Debug().Msg("Round opened")
+}
 
-	winners := l.db.Users.filterByDone(true).sortByLastEntryAsc()
-	win := func(u *User) error {
-		if !u.Done {
-			return nil
+// ProvisionalResults returns the results of the round so far, while still accepting entries
+// that arrive late, but with a timestamp inside the entry window.
+// The report is empty if there are no entries yet.
+func (l *Leet) ProvisionalResults(date time.Time) (RoundReport, error) {
+	if l == nil {
+		return RoundReport{}, ErrNilReceiver
+	}
+	for {
+		state := l.State()
+		if state != RoundIdle && state != RoundOpen && state != RoundCollecting {
+			return RoundReport{}, ErrRoundBusy
+		}
+		if l.state.CompareAndSwap(uint32(state), uint32(RoundProvisional)) {
+			break
 		}
-		return util.Fpf(w, " - Winner #%d!", winners.getIndex(u.Name)+1)
 	}
 
-	entryFormat := util.GetPadFormat(
-		l.db.Users.maxNameLen(),
-		": %04d @ %s Best: %s Bonus: %03dx = %04d Tax: %03dx = -%04d Miss: -%04d",
-	)
+	var rr RoundReport
+	err := l.do(func() {
+		l.provisional = l.round.ranking()
+		l.logger.Debug().Int("entries", len(l.round)).Msg("Provisional results")
+		rr = l.round.report("Provisional results (late arrivals may still change this) for", date)
+	})
+	return rr, err
+}
 
-	if err := util.Fpf(w, "Stats since %s:\n", l.db.BotStart.Format(time.RFC3339)); err != nil {
-		return err
+// CloseRound stops accepting entries for the round, applies taxes, and returns the results.
+// The results are saved to the store, if there is one.
+// The report is empty if there were no entries in the round.
+// All per round locks are released when done.
+func (l *Leet) CloseRound(ctx context.Context, date time.Time) (RoundReport, error) {
+	if l == nil {
+		return RoundReport{}, ErrNilReceiver
 	}
+	for {
+		state := l.State()
+		if state == RoundCalculating {
+			return RoundReport{}, ErrRoundBusy
+		}
+		if l.state.CompareAndSwap(uint32(state), uint32(RoundCalculating)) {
+			break
+		}
+	}
+	defer l.state.Store(uint32(RoundAnnounced))
 
-	for _, u := range l.db.Users.toSlice().sortByPointsDesc() {
-		if err := util.Fpf(
-			w,
-			entryFormat,
-			u.Name,
-			u.Scores.Total,
-			ltime.FormatLongDate(u.Entries.Last),
-			ltime.FormatLongDate(u.Entries.Best),
-			u.Bonuses.Times,
-			u.Bonuses.Total,
-			u.Taxes.Times,
-			u.Taxes.Total,
-			u.Missees.Total,
-		); err != nil {
-			return err
+	var (
+		round roundEntries
+		taxes taxEntries
+		db    *DB
+		rr    = RoundReport{Date: date}
+	)
+	err := l.do(func() {
+		defer l.db.Users.unlockAll()
+		round = l.round
+		provisional := l.provisional
+		l.round = nil
+		l.provisional = nil
+
+		l.logger.Debug().Int("entries", len(round)).Msg("Round closed")
+
+		if len(round) == 0 {
+			return
 		}
-		if err := win(u); err != nil {
-			return err
+
+		taxes = l.db.applyTaxes(round, l.tf.GetTargetScore(), l.rollDice)
+		db = l.db.clone()
+		rr = roundResults(round, taxes, provisional, date)
+	})
+	if err != nil {
+		return RoundReport{}, err
+	}
+
+	// Saving is done after the loop is free again, from a snapshot, as it might take a while
+	if db != nil && l.store != nil {
+		if saveErr := l.store.save(ctx, l.key, date.Format(time.DateOnly), db, round, taxes); saveErr != nil {
+			// the results are still valid, and will be saved with the next round or at shutdown
+			l.logger.Error().Err(saveErr).Msg("Failed to save round results")
 		}
-		if err := greet(u.Scores.Total); err != nil {
+	}
+	return rr, nil
+}
+
+func roundResults(round roundEntries, taxes taxEntries, provisional []string, date time.Time) RoundReport {
+	rr := round.report("Results for", date)
+	rr.Taxes = taxes.results()
+	// with nobody in the provisional results, there's no ranking to change from
+	if len(provisional) > 0 {
+		rr.RankChanges = rankChanges(provisional, round.ranking())
+	}
+	return rr
+}
+
+// Load reads the game state from the store, if there is one.
+// If the game is not in the store yet, or there is no store, the state is read from the JSON config file,
+// which is then imported into the store.
+func (l *Leet) Load(ctx context.Context) error {
+	if l == nil {
+		return ErrNilReceiver
+	}
+	if l.store != nil {
+		var db DB
+		found, err := l.store.load(ctx, l.key, &db)
+		if err != nil {
 			return err
 		}
-		if err := util.Fpf(w, "\n"); err != nil {
-			return err
+		if found {
+			return l.do(func() { l.db.replace(&db) })
 		}
+		l.logger.Info().Str("config_file", l.configFilePath).Msg("Game not found in database, importing config file")
+	}
+	if err := l.LoadConfigFile(); err != nil {
+		return err
+	}
+	if l.store != nil {
+		return l.Save(ctx)
 	}
-
 	return nil
 }
 
-func (l *Leet) Active() bool {
+// Save writes the game state to the store, if there is one, or to the JSON config file otherwise
+func (l *Leet) Save(ctx context.Context) error {
 	if l == nil {
-		return false
+		return ErrNilReceiver
+	}
+	if l.store == nil {
+		return l.SaveConfigFile()
+	}
+	db, err := l.snapshot()
+	if err != nil {
+		return err
 	}
-	return l.active.Load()
+	return l.store.save(ctx, l.key, "", db, nil, nil)
 }
 
 func (l *Leet) loadConfig(r io.Reader) error {
@@ -194,7 +443,11 @@ func (l *Leet) loadConfig(r io.Reader) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &l.db)
+	var db DB
+	if err = json.Unmarshal(data, &db); err != nil {
+		return err
+	}
+	return l.do(func() { l.db.replace(&db) })
 }
 
 func (l *Leet) LoadConfigFile() error {
@@ -212,17 +465,7 @@ func (l *Leet) LoadConfigFile() error {
 	return l.loadConfig(file)
 }
 
-func (l *Leet) saveConfig(w io.Writer) error {
-	data, err := json.MarshalIndent(&l.db, "", "  ") // save in pretty format, to make it easier to update config by hand
-	if err != nil {
-		return err
-	}
-	// add newline
-	data = append(data, '\n')
-	_, err = w.Write(data)
-	return err
-}
-
+// SaveConfigFile replaces the config file with the game state, keeping backups of the previous versions
 func (l *Leet) SaveConfigFile() error {
 	if l == nil {
 		return ErrNilReceiver
@@ -230,10 +473,15 @@ func (l *Leet) SaveConfigFile() error {
 	if l.configFilePath == "" {
 		return ErrNoConfigFile
 	}
-	file, err := os.Create(l.configFilePath)
+	db, err := l.snapshot()
 	if err != nil {
 		return err
 	}
-	defer l.logErrFn(file.Close)
-	return l.saveConfig(file)
+	data, err := marshalConfig(db)
+	if err != nil {
+		return err
+	}
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
+	return writeConfigFile(l.configFilePath, data, l.backups, l.now())
 }
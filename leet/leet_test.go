@@ -1,13 +1,35 @@
 package leet
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func testPlay(l *Leet, w io.Writer, user string, ts time.Time) error {
+	_, err := l.Play(context.Background(), w, user, ts, l.tf.Code(ts))
+	return err
+}
+
+// testCloseRound closes the round, and writes the results as text to w, if any
+func testCloseRound(l *Leet, w io.Writer, date time.Time) error {
+	rr, err := l.CloseRound(context.Background(), date)
+	if err != nil || rr.Empty() {
+		return err
+	}
+	return rr.WriteText(w, nil)
+}
+
 // visual inspection of output
 func Test_Leet_Stats(t *testing.T) {
 	t.Parallel()
@@ -28,8 +50,13 @@ func Test_Leet_Stats(t *testing.T) {
 		},
 	}
 
+	stats, err := l.Stats()
+	require.NoError(t, err)
 	var buf strings.Builder
-	l.Stats(&buf)
+	require.NoError(t, stats.WriteText(&buf, nil))
+	t.Log(buf.String())
+	buf.Reset()
+	require.NoError(t, stats.WriteHTML(&buf, nil))
 	t.Log(buf.String())
 }
 
@@ -59,3 +86,222 @@ func Test_Leet_handleFinishedPlayer(t *testing.T) {
 	l.handleFinishedPlayer(&buf, &u, time.Now())
 	t.Log(buf.String())
 }
+
+func Test_Leet_Play(t *testing.T) {
+	t.Parallel()
+
+	tf := ltime.TimeFrame{
+		Hour:         13,
+		Minute:       37,
+		WindowBefore: time.Minute,
+		WindowAfter:  time.Minute,
+	}
+	l := Leet{tf: tf}
+	ts := time.Date(2025, 5, 12, 13, 37, 0, 0, time.UTC)
+
+	var buf strings.Builder
+	outcome, err := l.Play(context.Background(), &buf, "user", ts, tf.Code(ts))
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeScored, outcome)
+	t.Log(buf.String())
+
+	u := l.db.Users.getUser("user")
+	assert.Equal(t, 11, u.Scores.Total)
+	assert.True(t, u.locked.Load())
+
+	// second entry in the same round should be rejected as spam
+	buf.Reset()
+	outcome, err = l.Play(context.Background(), &buf, "user", ts, tf.Code(ts))
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeSpam, outcome)
+	assert.Contains(t, buf.String(), "Stop spamming!")
+	assert.Equal(t, 11, u.Scores.Total)
+}
+
+func Test_Leet_Round(t *testing.T) {
+	t.Parallel()
+
+	tf := ltime.TimeFrame{
+		Hour:         13,
+		Minute:       37,
+		WindowBefore: time.Minute,
+		WindowAfter:  time.Minute,
+	}
+	l := Leet{tf: tf}
+	assert.Equal(t, RoundIdle, l.State())
+	assert.False(t, l.Active())
+
+	l.OpenRound()
+	assert.Equal(t, RoundOpen, l.State())
+
+	var buf strings.Builder
+	assert.NoError(t, testPlay(&l, &buf, "first", time.Date(2025, 5, 12, 13, 37, 1, 0, time.UTC)))
+	assert.Equal(t, RoundCollecting, l.State())
+	assert.True(t, l.Active())
+	assert.NoError(t, testPlay(&l, &buf, "second", time.Date(2025, 5, 12, 13, 37, 0, 1, time.UTC)))
+	assert.NoError(t, testPlay(&l, &buf, "third", time.Date(2025, 5, 12, 13, 38, 1, 0, time.UTC)))
+
+	buf.Reset()
+	assert.NoError(t, testCloseRound(&l, &buf, time.Date(2025, 5, 12, 13, 39, 0, 0, time.UTC)))
+	t.Log(buf.String())
+	assert.Equal(t, RoundAnnounced, l.State())
+	assert.False(t, l.Active())
+	assert.Contains(t, buf.String(), "#1 second")
+	assert.Contains(t, buf.String(), "#2 first")
+	assert.Contains(t, buf.String(), "third: too late")
+	assert.False(t, l.db.Users.getUser("first").locked.Load())
+
+	// entries after the round is closed are rejected
+	buf.Reset()
+	assert.NoError(t, testPlay(&l, &buf, "late", time.Date(2025, 5, 12, 13, 38, 59, 0, time.UTC)))
+	assert.Contains(t, buf.String(), "round is already closed")
+	assert.Equal(t, 0, l.db.Users.getUser("late").Scores.Total)
+
+	// nothing to announce for an empty round
+	l.OpenRound()
+	buf.Reset()
+	assert.NoError(t, testCloseRound(&l, &buf, time.Now()))
+	assert.Empty(t, buf.String())
+}
+
+func Test_RoundState_String(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, rsNameIdle, RoundIdle.String())
+	assert.Equal(t, rsNameOpen, RoundOpen.String())
+	assert.Equal(t, rsNameCollecting, RoundCollecting.String())
+	assert.Equal(t, rsNameProvisional, RoundProvisional.String())
+	assert.Equal(t, rsNameCalculating, RoundCalculating.String())
+	assert.Equal(t, rsNameAnnounced, RoundAnnounced.String())
+	assert.Equal(t, rsNameInvalid, RoundState(99).String())
+}
+
+func Test_Leet_ProvisionalResults(t *testing.T) {
+	t.Parallel()
+
+	tf := ltime.TimeFrame{
+		Hour:         13,
+		Minute:       37,
+		WindowBefore: time.Minute,
+		WindowAfter:  time.Minute,
+	}
+	l := Leet{tf: tf}
+	date := time.Date(2025, 5, 12, 13, 39, 0, 0, time.UTC)
+
+	l.OpenRound()
+	var buf strings.Builder
+	assert.NoError(t, testPlay(&l, &buf, "first", time.Date(2025, 5, 12, 13, 37, 1, 0, time.UTC)))
+
+	buf.Reset()
+	rr, err := l.ProvisionalResults(date)
+	require.NoError(t, err)
+	assert.NoError(t, rr.WriteText(&buf, nil))
+	t.Log(buf.String())
+	assert.Equal(t, RoundProvisional, l.State())
+	assert.Contains(t, buf.String(), "Provisional results")
+	assert.Contains(t, buf.String(), "#1 first")
+	_, err = l.ProvisionalResults(date)
+	assert.ErrorIs(t, err, ErrRoundBusy)
+
+	// a late arrival with a better timestamp is still accepted
+	buf.Reset()
+	assert.NoError(t, testPlay(&l, &buf, "second", time.Date(2025, 5, 12, 13, 37, 0, 1000, time.UTC)))
+	assert.NotContains(t, buf.String(), "closed")
+
+	buf.Reset()
+	assert.NoError(t, testCloseRound(&l, &buf, date))
+	t.Log(buf.String())
+	assert.Contains(t, buf.String(), "#1 second")
+	assert.Contains(t, buf.String(), "second: late arrival, placed #1")
+	assert.Contains(t, buf.String(), "first: #1 -> #2")
+}
+
+func Test_Leet_ProvisionalResults_empty(t *testing.T) {
+	t.Parallel()
+
+	tf := ltime.TimeFrame{
+		Hour:         13,
+		Minute:       37,
+		WindowBefore: time.Minute,
+		WindowAfter:  time.Minute,
+	}
+	l := Leet{tf: tf}
+	date := time.Date(2025, 5, 12, 13, 39, 0, 0, time.UTC)
+
+	l.OpenRound()
+	rr, err := l.ProvisionalResults(date)
+	require.NoError(t, err)
+	assert.True(t, rr.Empty())
+
+	// nobody was in the provisional results, so nobody moved from them
+	var buf strings.Builder
+	assert.NoError(t, testPlay(&l, &buf, "late", time.Date(2025, 5, 12, 13, 37, 0, 1000, time.UTC)))
+	buf.Reset()
+	assert.NoError(t, testCloseRound(&l, &buf, date))
+	assert.Contains(t, buf.String(), "#1 late")
+	assert.NotContains(t, buf.String(), "late arrival")
+}
+
+func Test_rankChanges(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, rankChanges([]string{"a", "b"}, []string{"a", "b"}))
+	assert.Equal(
+		t,
+		[]RankChange{{Name: "c", To: 2}, {Name: "b", From: 2, To: 3}},
+		rankChanges([]string{"a", "b"}, []string{"a", "c", "b"}),
+	)
+}
+
+// Meant to be run with -race, to check that nothing touches the game state outside of the event loop
+func Test_Leet_concurrent(t *testing.T) {
+	t.Parallel()
+
+	l := New(zerolog.Nop(), Config{ConfigFile: filepath.Join(t.TempDir(), "config.json"), Backups: 1, TimeFrame: testTimeFrame()})
+	defer l.Close()
+	l.OpenRound()
+	ts := time.Date(2025, 5, 12, 13, 37, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			var buf strings.Builder
+			assert.NoError(t, testPlay(l, &buf, fmt.Sprintf("user%d", i%5), ts.Add(time.Duration(i)*time.Millisecond)))
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := l.Stats()
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, l.SaveConfigFile())
+		}()
+	}
+	wg.Wait()
+
+	var buf strings.Builder
+	require.NoError(t, testCloseRound(l, &buf, ts))
+	// each user only gets one entry per round, no matter how many times they try
+	assert.Equal(t, 5, strings.Count(buf.String(), "\n#"))
+
+	db, err := l.snapshot()
+	require.NoError(t, err)
+	for _, u := range db.Users.Users {
+		assert.Equal(t, 1, u.Scores.Times, u.Name)
+	}
+}
+
+func Test_Leet_Close(t *testing.T) {
+	t.Parallel()
+
+	l := New(zerolog.Nop(), Config{})
+	l.Close()
+	l.Close()
+	_, err := l.Stats()
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, testPlay(l, io.Discard, "user", time.Now()), ErrClosed)
+	(*Leet)(nil).Close()
+}
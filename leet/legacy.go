@@ -0,0 +1,269 @@
+package leet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/util"
+)
+
+var (
+	ErrNoLegacyChannel      = errors.New("no channel given, and the legacy file does not have exactly one")
+	ErrUnknownLegacyChannel = errors.New("channel not found in legacy file")
+)
+
+// The format used by the 1337 module of the IRC bot dvdgbot, where everything is kept per channel.
+// Only what has a counterpart in DB is decoded. Anything else is reported by ImportLegacy.
+type legacyBonusConfig struct {
+	Greeting     string `json:"greeting"`
+	SubString    string `json:"substring"`
+	PrefixChar   string `json:"prefixchar"`
+	StepPoints   int    `json:"steppoints"`
+	NoStepPoints int    `json:"nosteppoints"`
+	UseStep      bool   `json:"usestep"`
+}
+
+type legacyUser struct {
+	Nick      string    `json:"nick"`
+	Points    int       `json:"points"`
+	LastEntry time.Time `json:"last_entry"`
+	BestEntry time.Time `json:"best_entry"`
+	Locked    bool      `json:"locked"`
+}
+
+type legacyChannel struct {
+	Users map[string]*legacyUser `json:"users"`
+}
+
+type legacyData struct {
+	BotStart      time.Time                 `json:"botstart"`
+	Channels      map[string]*legacyChannel `json:"channels"`
+	BonusConfigs  []legacyBonusConfig       `json:"bonusconfigs"`
+	InspectionTax float64                   `json:"inspection_tax"`
+	OvershootTax  int                       `json:"overshoot_tax"`
+	InspectAlways bool                      `json:"inspect_always"`
+	TaxLoners     bool                      `json:"tax_loners"`
+}
+
+// LegacyReport lists what was, and was not, converted by ImportLegacy
+type LegacyReport struct {
+	Imported []string // nick -> user ID
+	Problems []string // anything that could not be converted as is
+}
+
+func (lr *LegacyReport) problem(format string, args ...any) {
+	lr.Problems = append(lr.Problems, fmt.Sprintf(format, args...))
+}
+
+// Write writes a human readable version of the report to w
+func (lr LegacyReport) Write(w io.Writer) error {
+	if err := util.Fpf(w, "Imported %d user(s):\n", len(lr.Imported)); err != nil {
+		return err
+	}
+	for _, line := range lr.Imported {
+		if err := util.Fpf(w, "  %s\n", line); err != nil {
+			return err
+		}
+	}
+	if len(lr.Problems) == 0 {
+		return nil
+	}
+	if err := util.Fpf(w, "Could not convert:\n"); err != nil {
+		return err
+	}
+	for _, line := range lr.Problems {
+		if err := util.Fpf(w, "  %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unknownKeys returns the keys in the JSON object data, that are not in known, sorted
+func unknownKeys(data json.RawMessage, known ...string) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	var res []string
+	for k := range fields {
+		if !slices.Contains(known, k) {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// legacyUserKeys returns the unknown keys for each user in the given channel
+func legacyUserKeys(data []byte, channel string) map[string][]string {
+	var raw struct {
+		Channels map[string]struct {
+			Users map[string]json.RawMessage `json:"users"`
+		} `json:"channels"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil
+	}
+	res := make(map[string][]string)
+	for nick, user := range raw.Channels[channel].Users {
+		if keys := unknownKeys(user, "nick", "points", "last_entry", "best_entry", "locked"); len(keys) > 0 {
+			res[nick] = keys
+		}
+	}
+	return res
+}
+
+func convertLegacyBonus(lbc legacyBonusConfig) (BonusConfig, error) {
+	subVal, err := strconv.Atoi(lbc.SubString)
+	if err != nil {
+		return BonusConfig{}, fmt.Errorf("substring %q is not a number", lbc.SubString)
+	}
+	prefix := []rune(lbc.PrefixChar)
+	if len(prefix) > 1 {
+		return BonusConfig{}, fmt.Errorf("prefix %q is more than one character", lbc.PrefixChar)
+	}
+	bc := BonusConfig{
+		Greeting:     lbc.Greeting,
+		SubVal:       subVal,
+		StepPoints:   lbc.StepPoints,
+		NoStepPoints: lbc.NoStepPoints,
+		UseStep:      lbc.UseStep,
+	}
+	if len(prefix) == 1 {
+		bc.PrefixChar = prefix[0]
+	}
+	return bc, nil
+}
+
+// mergeLegacyUser adds the legacy user to u, so that several nicks can be merged into one user
+func mergeLegacyUser(u *User, lu *legacyUser) {
+	u.Scores.Total += lu.Points
+	if lu.LastEntry.After(u.Entries.Last) {
+		u.Entries.Last = lu.LastEntry
+	}
+	// The best entry is the one closest to the target, which is what the sub second part tells
+	if !lu.BestEntry.IsZero() && (u.Entries.Best.IsZero() || subSecondString(lu.BestEntry) < subSecondString(u.Entries.Best)) {
+		u.Entries.Best = lu.BestEntry
+	}
+	u.Done = u.Done || lu.Locked
+}
+
+// convertLegacy converts the given channel from the legacy data into db.
+// Nicks are looked up in users case-insensitively, as IRC nicks are.
+func convertLegacy(data []byte, channel string, users map[string]string, db *DB) (LegacyReport, error) {
+	var (
+		ld     legacyData
+		report LegacyReport
+	)
+	if err := json.Unmarshal(data, &ld); err != nil {
+		return report, err
+	}
+
+	if channel == "" {
+		if len(ld.Channels) != 1 {
+			return report, ErrNoLegacyChannel
+		}
+		for name := range ld.Channels {
+			channel = name
+		}
+	}
+	ch, ok := ld.Channels[channel]
+	if !ok || ch == nil {
+		return report, fmt.Errorf("%w: %s", ErrUnknownLegacyChannel, channel)
+	}
+	for name := range ld.Channels {
+		if name != channel {
+			report.problem("channel %s: skipped, only %s is imported", name, channel)
+		}
+	}
+
+	for _, key := range unknownKeys(data, "botstart", "channels", "bonusconfigs", "inspection_tax", "overshoot_tax", "inspect_always", "tax_loners") {
+		report.problem("setting %q: not supported", key)
+	}
+
+	db.BotStart = ld.BotStart
+	db.GameCfg = LeetConfig{
+		InspectionTax: int(math.Round(ld.InspectionTax)),
+		OvershootTax:  ld.OvershootTax,
+		InspectAlways: ld.InspectAlways,
+		TaxLoners:     ld.TaxLoners,
+	}
+	if float64(db.GameCfg.InspectionTax) != ld.InspectionTax {
+		report.problem("inspection_tax: %v rounded to %d", ld.InspectionTax, db.GameCfg.InspectionTax)
+	}
+	for i, lbc := range ld.BonusConfigs {
+		bc, err := convertLegacyBonus(lbc)
+		if err != nil {
+			report.problem("bonus config #%d: %s", i+1, err.Error())
+			continue
+		}
+		db.BonusCfgs = append(db.BonusCfgs, bc)
+	}
+
+	lowerUsers := make(map[string]string, len(users))
+	for nick, userID := range users {
+		lowerUsers[strings.ToLower(nick)] = userID
+	}
+	nicks := make([]string, 0, len(ch.Users))
+	for nick := range ch.Users {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+
+	userKeys := legacyUserKeys(data, channel)
+	for _, nick := range nicks {
+		lu := ch.Users[nick]
+		if lu == nil {
+			report.problem("user %s: no data", nick)
+			continue
+		}
+		userID, ok := lowerUsers[strings.ToLower(nick)]
+		if !ok {
+			report.problem("user %s: no user ID in mapping, skipped with %d points", nick, lu.Points)
+			continue
+		}
+		if _, exists := db.Users.Users[userID]; exists {
+			report.problem("user %s: merged into %s, which has more than one nick", nick, userID)
+		}
+		mergeLegacyUser(db.Users.getUser(userID), lu)
+		report.Imported = append(report.Imported, fmt.Sprintf("%s -> %s", nick, userID))
+		if keys := userKeys[nick]; len(keys) > 0 {
+			report.problem("user %s: fields not supported: %s", nick, strings.Join(keys, ", "))
+		}
+	}
+
+	return report, nil
+}
+
+// ImportLegacy replaces the game state with the given channel from a dvdgbot 1337 file.
+// If channel is empty, the file must contain exactly one channel.
+// Users are mapped from IRC nicks to user IDs by users, and those not found are skipped.
+// The state must be saved afterwards, to keep it.
+func (l *Leet) ImportLegacy(r io.Reader, channel string, users map[string]string) (LegacyReport, error) {
+	if l == nil {
+		return LegacyReport{}, ErrNilReceiver
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return LegacyReport{}, err
+	}
+	room, err := l.GetRoom()
+	if err != nil {
+		return LegacyReport{}, err
+	}
+	db := DB{Room: room}
+	report, err := convertLegacy(data, channel, users, &db)
+	if err != nil {
+		return report, err
+	}
+	return report, l.do(func() { l.db.replace(&db) })
+}
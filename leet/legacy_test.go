@@ -0,0 +1,101 @@
+package leet
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLegacyData = `{
+  "botstart": "2019-01-02T13:00:00Z",
+  "inspection_tax": 12.5,
+  "overshoot_tax": 30,
+  "tax_loners": true,
+  "post_tax_fail": true,
+  "bonusconfigs": [
+    {"substring": "1337", "prefixchar": "0", "usestep": true, "steppoints": 10, "greeting": "Wow"},
+    {"substring": "leet", "greeting": "Nope"}
+  ],
+  "channels": {
+    "#leet": {
+      "users": {
+        "Odd": {"nick": "Odd", "points": 900, "locked": true, "last_entry": "2024-01-01T13:37:00.5Z", "best_entry": "2023-06-01T13:37:00.000001Z"},
+        "odd_": {"nick": "odd_", "points": 100, "last_entry": "2024-02-01T13:37:00.1Z", "best_entry": "2023-01-01T13:37:00.01Z", "tax_count": 2},
+        "lurker": {"nick": "lurker", "points": 5}
+      }
+    },
+    "#other": {"users": {}}
+  }
+}`
+
+func Test_convertLegacy(t *testing.T) {
+	t.Parallel()
+
+	users := map[string]string{
+		"odd":  "@odd:test.com",
+		"ODD_": "@odd:test.com",
+	}
+
+	var db DB
+	_, err := convertLegacy([]byte(testLegacyData), "", users, &db)
+	assert.ErrorIs(t, err, ErrNoLegacyChannel)
+	_, err = convertLegacy([]byte(testLegacyData), "#nope", users, &db)
+	assert.ErrorIs(t, err, ErrUnknownLegacyChannel)
+
+	report, err := convertLegacy([]byte(testLegacyData), "#leet", users, &db)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Odd -> @odd:test.com", "odd_ -> @odd:test.com"}, report.Imported)
+	assert.ElementsMatch(
+		t,
+		[]string{
+			"channel #other: skipped, only #leet is imported",
+			`setting "post_tax_fail": not supported`,
+			"inspection_tax: 12.5 rounded to 13",
+			`bonus config #2: substring "leet" is not a number`,
+			"user lurker: no user ID in mapping, skipped with 5 points",
+			"user odd_: merged into @odd:test.com, which has more than one nick",
+			"user odd_: fields not supported: tax_count",
+		},
+		report.Problems,
+	)
+
+	assert.Equal(t, LeetConfig{InspectionTax: 13, OvershootTax: 30, TaxLoners: true}, db.GameCfg)
+	assert.Equal(t, BonusConfigs{{Greeting: "Wow", SubVal: 1337, StepPoints: 10, PrefixChar: '0', UseStep: true}}, db.BonusCfgs)
+	assert.True(t, db.BotStart.Equal(time.Date(2019, 1, 2, 13, 0, 0, 0, time.UTC)))
+
+	require.Len(t, db.Users.Users, 1)
+	u := db.Users.Users["@odd:test.com"]
+	require.NotNil(t, u)
+	assert.Equal(t, 1000, u.Scores.Total)
+	assert.True(t, u.Done)
+	assert.True(t, u.Entries.Last.Equal(time.Date(2024, 2, 1, 13, 37, 0, 100000000, time.UTC)))
+	assert.True(t, u.Entries.Best.Equal(time.Date(2023, 6, 1, 13, 37, 0, 1000, time.UTC)))
+}
+
+func Test_Leet_ImportLegacy(t *testing.T) {
+	t.Parallel()
+
+	l := New(zerolog.Nop(), Config{Room: "!room:test.com"})
+	l.db.Users.getUser("@old:test.com")
+
+	_, err := l.ImportLegacy(strings.NewReader("{"), "", nil)
+	assert.Error(t, err)
+	assert.Contains(t, l.db.Users.Users, "@old:test.com", "state should be kept on errors")
+
+	report, err := l.ImportLegacy(strings.NewReader(`{"channels": {"#leet": {"users": {"nick": {"points": 3}}}}}`), "", map[string]string{"nick": "@nick:test.com"})
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	assert.NotContains(t, l.db.Users.Users, "@old:test.com")
+	assert.Equal(t, 3, l.db.Users.getUser("@nick:test.com").Scores.Total)
+	room, err := l.GetRoom()
+	require.NoError(t, err)
+	assert.Equal(t, "!room:test.com", room)
+
+	var buf strings.Builder
+	require.NoError(t, report.Write(&buf))
+	assert.Equal(t, "Imported 1 user(s):\n  nick -> @nick:test.com\n", buf.String())
+}
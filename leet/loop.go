@@ -0,0 +1,63 @@
+package leet
+
+import "errors"
+
+var ErrClosed = errors.New("game is closed")
+
+// start sets up the event loop, the first time it's needed
+func (l *Leet) start() {
+	l.loopOnce.Do(func() {
+		l.cmds = make(chan func())
+		l.quit = make(chan struct{})
+		go l.loop()
+	})
+}
+
+// loop runs commands one at a time, in the order they come in.
+// This makes it the only place the game state is changed, so no other locking is needed for it.
+func (l *Leet) loop() {
+	for {
+		select {
+		case cmd := <-l.cmds:
+			cmd()
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// do runs f on the event loop, and waits for it to finish.
+// f must not call do, as that would never finish.
+func (l *Leet) do(f func()) error {
+	l.start()
+	done := make(chan struct{})
+	select {
+	case l.cmds <- func() {
+		defer close(done)
+		f()
+	}:
+	case <-l.quit:
+		return ErrClosed
+	}
+	<-done
+	return nil
+}
+
+// snapshot returns a copy of the game state, that is safe to read while the game goes on
+func (l *Leet) snapshot() (*DB, error) {
+	var db *DB
+	if err := l.do(func() { db = l.db.clone() }); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Close stops the event loop. The game can not be played or changed after this, but snapshots already taken are
+// still valid.
+func (l *Leet) Close() {
+	if l == nil {
+		return
+	}
+	l.start()
+	l.closeOnce.Do(func() { close(l.quit) })
+}
@@ -0,0 +1,302 @@
+package leet
+
+import (
+	"html/template"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/oddlid/leetbot_matrix/util"
+)
+
+// NameFunc returns the name to show for a player, who is known by user ID in the game
+type NameFunc func(userID string) string
+
+func (nf NameFunc) name(userID string) string {
+	if nf == nil {
+		return userID
+	}
+	return nf(userID)
+}
+
+// Report is something to show to the players, that can be written as plain text or HTML,
+// so that each transport can use the best format it supports.
+// Players are shown with the names given by name, or by user ID if nil.
+type Report interface {
+	WriteText(w io.Writer, name NameFunc) error
+	WriteHTML(w io.Writer, name NameFunc) error
+}
+
+// RoundResult is a placement in a round
+type RoundResult struct {
+	Rank   int
+	Name   string
+	TS     time.Time
+	Points int // without bonus
+	Bonus  int
+	Total  int
+	Done   bool
+}
+
+// NearMiss is an entry that was not on time, but close enough to count
+type NearMiss struct {
+	Name     string
+	Code     ltime.TimeCode
+	MissedBy time.Duration
+	Points   int
+	Total    int
+}
+
+// TaxResult is a tax paid at the end of a round
+type TaxResult struct {
+	Name   string
+	Kind   string
+	Amount int
+	Total  int
+}
+
+// RankChange is how a placement changed from the provisional to the final results
+type RankChange struct {
+	Name string
+	From int // 0 for a late arrival
+	To   int
+}
+
+// RoundReport is the outcome of a round, either provisional or final
+type RoundReport struct {
+	Heading     string
+	Date        time.Time
+	Results     []RoundResult
+	NearMisses  []NearMiss
+	Taxes       []TaxResult
+	RankChanges []RankChange
+}
+
+// Empty returns true if nobody played in the round, in which case there's nothing to show
+func (rr RoundReport) Empty() bool {
+	return len(rr.Results) == 0 && len(rr.NearMisses) == 0
+}
+
+// StatsRow is the standing of a user
+type StatsRow struct {
+	Name       string
+	Points     int
+	Last       time.Time
+	Best       time.Time
+	BonusTimes int
+	BonusTotal int
+	TaxTimes   int
+	TaxTotal   int
+	Misses     int
+	Winner     int // placement among those who have reached the target, 0 if not there yet
+	Greeting   string
+}
+
+// StatsReport is the current standings, highest score first
+type StatsReport struct {
+	Since time.Time
+	Rows  []StatsRow
+}
+
+func timeStampFull(t time.Time) string {
+	var sb strings.Builder
+	_ = ltime.FormatTimeStampFull(&sb, t) // writing to a strings.Builder never fails
+	return sb.String()
+}
+
+// WriteText writes the results as lines of plain text
+func (rr RoundReport) WriteText(w io.Writer, name NameFunc) error {
+	if err := util.Fpf(w, "%s %s:\n", rr.Heading, rr.Date.Format(time.DateOnly)); err != nil {
+		return err
+	}
+
+	for _, r := range rr.Results {
+		if err := util.Fpf(w, "#%d %s %s +%d", r.Rank, name.name(r.Name), timeStampFull(r.TS), r.Points); err != nil {
+			return err
+		}
+		if r.Bonus > 0 {
+			if err := util.Fpf(w, " +%d bonus", r.Bonus); err != nil {
+				return err
+			}
+		}
+		if err := util.Fpf(w, " = %d", r.Total); err != nil {
+			return err
+		}
+		if r.Done {
+			if err := util.Fpf(w, " - DONE!"); err != nil {
+				return err
+			}
+		}
+		if err := util.Fpf(w, "\n"); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range rr.NearMisses {
+		if err := util.Fpf(w, "%s: too %s by %s, %d = %d\n", name.name(m.Name), m.Code.String(), m.MissedBy.String(), m.Points, m.Total); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range rr.Taxes {
+		if err := util.Fpf(w, "%s: %s tax -%d = %d\n", name.name(t.Name), t.Kind, t.Amount, t.Total); err != nil {
+			return err
+		}
+	}
+
+	if len(rr.RankChanges) > 0 {
+		if err := util.Fpf(w, "Changes since the provisional results:\n"); err != nil {
+			return err
+		}
+	}
+	for _, c := range rr.RankChanges {
+		var err error
+		if c.From > 0 {
+			err = util.Fpf(w, "%s: #%d -> #%d\n", name.name(c.Name), c.From, c.To)
+		} else {
+			err = util.Fpf(w, "%s: late arrival, placed #%d\n", name.name(c.Name), c.To)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteText writes the standings as lines of plain text, with the names padded to line up in a monospace font
+func (sr StatsReport) WriteText(w io.Writer, name NameFunc) error {
+	names := make([]string, len(sr.Rows))
+	maxLen := 0
+	for i, r := range sr.Rows {
+		names[i] = name.name(r.Name)
+		maxLen = max(maxLen, utf8.RuneCountInString(names[i]))
+	}
+	entryFormat := util.GetPadFormat(
+		maxLen,
+		": %04d @ %s Best: %s Bonus: %03dx = %04d Tax: %03dx = -%04d Miss: -%04d",
+	)
+
+	if err := util.Fpf(w, "Stats since %s:\n", sr.Since.Format(time.RFC3339)); err != nil {
+		return err
+	}
+
+	for i, r := range sr.Rows {
+		if err := util.Fpf(
+			w,
+			entryFormat,
+			names[i],
+			r.Points,
+			ltime.FormatLongDate(r.Last),
+			ltime.FormatLongDate(r.Best),
+			r.BonusTimes,
+			r.BonusTotal,
+			r.TaxTimes,
+			r.TaxTotal,
+			r.Misses,
+		); err != nil {
+			return err
+		}
+		if r.Winner > 0 {
+			if err := util.Fpf(w, " - Winner #%d!", r.Winner); err != nil {
+				return err
+			}
+		}
+		if r.Greeting != "" {
+			if err := util.Fpf(w, " - %s", r.Greeting); err != nil {
+				return err
+			}
+		}
+		if err := util.Fpf(w, "\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The HTML sticks to what the Matrix spec allows in formatted messages
+var htmlTemplates = template.Must(
+	template.New("").Funcs(
+		template.FuncMap{
+			"date":     func(t time.Time) string { return t.Format(time.DateOnly) },
+			"ts":       timeStampFull,
+			"longDate": ltime.FormatLongDate,
+			"rfc3339":  func(t time.Time) string { return t.Format(time.RFC3339) },
+		},
+	).Parse(`
+{{- define "round" -}}
+{{- with .Report -}}
+<p><strong>{{ .Heading }} {{ date .Date }}</strong></p>
+{{- if .Results }}
+<table>
+<thead><tr><th>#</th><th>Name</th><th>Time</th><th>Points</th><th>Total</th></tr></thead>
+<tbody>
+{{- range .Results }}
+<tr><td>{{ .Rank }}</td><td>{{ if eq .Rank 1 }}<strong>{{ $.Name .Name }}</strong>{{ else }}{{ $.Name .Name }}{{ end }}</td><td><code>{{ ts .TS }}</code></td><td>+{{ .Points }}{{ if gt .Bonus 0 }} +{{ .Bonus }} bonus{{ end }}</td><td>{{ .Total }}{{ if .Done }} <strong>DONE!</strong>{{ end }}</td></tr>
+{{- end }}
+</tbody>
+</table>
+{{- end }}
+{{- if .NearMisses }}
+<ul>
+{{- range .NearMisses }}
+<li>{{ $.Name .Name }}: too {{ .Code.String }} by <code>{{ .MissedBy.String }}</code>, {{ .Points }} = {{ .Total }}</li>
+{{- end }}
+</ul>
+{{- end }}
+{{- if .Taxes }}
+<ul>
+{{- range .Taxes }}
+<li>{{ $.Name .Name }}: {{ .Kind }} tax -{{ .Amount }} = {{ .Total }}</li>
+{{- end }}
+</ul>
+{{- end }}
+{{- if .RankChanges }}
+<p>Changes since the provisional results:</p>
+<ul>
+{{- range .RankChanges }}
+<li>{{ $.Name .Name }}: {{ if .From }}#{{ .From }} -&gt; #{{ .To }}{{ else }}late arrival, placed #{{ .To }}{{ end }}</li>
+{{- end }}
+</ul>
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- define "stats" -}}
+{{- with .Report -}}
+<p><strong>Stats since {{ rfc3339 .Since }}</strong></p>
+<table>
+<thead><tr><th>Name</th><th>Points</th><th>Last</th><th>Best</th><th>Bonus</th><th>Tax</th><th>Miss</th><th></th></tr></thead>
+<tbody>
+{{- range .Rows }}
+<tr><td>{{ if .Winner }}<strong>{{ $.Name .Name }}</strong>{{ else }}{{ $.Name .Name }}{{ end }}</td><td>{{ .Points }}</td><td><code>{{ longDate .Last }}</code></td><td><code>{{ longDate .Best }}</code></td><td>{{ .BonusTimes }}x = {{ .BonusTotal }}</td><td>{{ .TaxTimes }}x = -{{ .TaxTotal }}</td><td>-{{ .Misses }}</td><td>{{ if .Winner }}<strong>Winner #{{ .Winner }}!</strong>{{ end }}{{ if and .Winner .Greeting }} {{ end }}{{ .Greeting }}</td></tr>
+{{- end }}
+</tbody>
+</table>
+{{- end }}
+{{- end -}}
+`),
+)
+
+// htmlData is what the templates get, so that they can look up names
+type htmlData struct {
+	Report any
+	name   NameFunc
+}
+
+func (hd htmlData) Name(userID string) string {
+	return hd.name.name(userID)
+}
+
+// WriteHTML writes the results as HTML, with a table of the placements
+func (rr RoundReport) WriteHTML(w io.Writer, name NameFunc) error {
+	return htmlTemplates.ExecuteTemplate(w, "round", htmlData{Report: rr, name: name})
+}
+
+// WriteHTML writes the standings as an HTML table
+func (sr StatsReport) WriteHTML(w io.Writer, name NameFunc) error {
+	return htmlTemplates.ExecuteTemplate(w, "stats", htmlData{Report: sr, name: name})
+}
@@ -0,0 +1,142 @@
+package leet
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRoundReport() RoundReport {
+	ts := time.Date(2025, 5, 12, 13, 37, 0, 123000000, time.UTC)
+	return RoundReport{
+		Heading: "Results for",
+		Date:    ts,
+		Results: []RoundResult{
+			{Rank: 1, Name: "@alice:test.com", TS: ts, Points: 3, Bonus: 2, Total: 1337, Done: true},
+			{Rank: 2, Name: "<b>bob</b>", TS: ts.Add(time.Second), Points: 1, Total: 10},
+		},
+		NearMisses:  []NearMiss{{Name: "carol", Code: ltime.TCEarly, MissedBy: 100 * time.Millisecond, Total: 5}},
+		Taxes:       []TaxResult{{Name: "@alice:test.com", Kind: tkNameInspection, Amount: 1, Total: 1336}},
+		RankChanges: []RankChange{{Name: "@alice:test.com", To: 1}, {Name: "<b>bob</b>", From: 1, To: 2}},
+	}
+}
+
+func Test_RoundReport_WriteText(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	require.NoError(t, testRoundReport().WriteText(&buf, nil))
+	assert.Equal(
+		t,
+		"Results for 2025-05-12:\n"+
+			"#1 @alice:test.com [13:37:00:123000000] +3 +2 bonus = 1337 - DONE!\n"+
+			"#2 <b>bob</b> [13:37:01:123000000] +1 = 10\n"+
+			"carol: too early by 100ms, 0 = 5\n"+
+			"@alice:test.com: inspection tax -1 = 1336\n"+
+			"Changes since the provisional results:\n"+
+			"@alice:test.com: late arrival, placed #1\n"+
+			"<b>bob</b>: #1 -> #2\n",
+		buf.String(),
+	)
+	assert.False(t, testRoundReport().Empty())
+	assert.True(t, RoundReport{}.Empty())
+}
+
+func Test_RoundReport_WriteHTML(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	require.NoError(t, testRoundReport().WriteHTML(&buf, nil))
+	html := buf.String()
+	t.Log(html)
+	assert.True(t, strings.HasPrefix(html, "<p><strong>Results for 2025-05-12</strong></p>\n<table>"))
+	assert.Contains(t, html, "<td><strong>@alice:test.com</strong></td>")
+	assert.Contains(t, html, "<td><code>[13:37:00:123000000]</code></td><td>+3 +2 bonus</td>")
+	assert.Contains(t, html, "<td>1337 <strong>DONE!</strong></td>")
+	// names are escaped
+	assert.Contains(t, html, "<td>&lt;b&gt;bob&lt;/b&gt;</td>")
+	assert.NotContains(t, html, "<b>bob</b>")
+	assert.Contains(t, html, "<li>carol: too early by <code>100ms</code>, 0 = 5</li>")
+	assert.Contains(t, html, "<li>@alice:test.com: inspection tax -1 = 1336</li>")
+	assert.Contains(t, html, "<li>@alice:test.com: late arrival, placed #1</li>")
+	assert.Contains(t, html, "<li>&lt;b&gt;bob&lt;/b&gt;: #1 -&gt; #2</li>")
+
+	// no table without placements
+	buf.Reset()
+	require.NoError(t, RoundReport{Heading: "Results for", NearMisses: []NearMiss{{Name: "carol", Code: ltime.TCLate}}}.WriteHTML(&buf, nil))
+	assert.NotContains(t, buf.String(), "<table>")
+}
+
+func Test_StatsReport(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Date(2025, 5, 12, 13, 37, 0, 0, time.UTC)
+	sr := StatsReport{
+		Since: ts,
+		Rows: []StatsRow{
+			{Name: "@alice:test.com", Points: 1337, Last: ts, Best: ts, BonusTimes: 1, BonusTotal: 2, Winner: 1, Greeting: "Hi"},
+			{Name: "bob", Points: 3, Last: ts, Best: ts, TaxTimes: 1, TaxTotal: 1, Misses: 4},
+		},
+	}
+
+	var buf strings.Builder
+	require.NoError(t, sr.WriteText(&buf, nil))
+	assert.Equal(
+		t,
+		"Stats since 2025-05-12T13:37:00Z:\n"+
+			"@alice:test.com : 1337 @ 2025-05-12 13:37:00.000000000 Best: 2025-05-12 13:37:00.000000000 "+
+			"Bonus: 001x = 0002 Tax: 000x = -0000 Miss: -0000 - Winner #1! - Hi\n"+
+			"bob             : 0003 @ 2025-05-12 13:37:00.000000000 Best: 2025-05-12 13:37:00.000000000 "+
+			"Bonus: 000x = 0000 Tax: 001x = -0001 Miss: -0004\n",
+		buf.String(),
+	)
+
+	buf.Reset()
+	require.NoError(t, sr.WriteHTML(&buf, nil))
+	html := buf.String()
+	t.Log(html)
+	assert.True(t, strings.HasPrefix(html, "<p><strong>Stats since 2025-05-12T13:37:00Z</strong></p>\n<table>"))
+	assert.Contains(t, html, "<tr><td><strong>@alice:test.com</strong></td><td>1337</td>")
+	assert.Contains(t, html, "<td><strong>Winner #1!</strong> Hi</td>")
+	assert.Contains(t, html, "<tr><td>bob</td><td>3</td>")
+	assert.Contains(t, html, "<td><code>2025-05-12 13:37:00.000000000</code></td>")
+	assert.Contains(t, html, "<td>1x = -1</td><td>-4</td><td></td></tr>")
+}
+
+func Test_Report_names(t *testing.T) {
+	t.Parallel()
+
+	names := map[string]string{"@alice:test.com": "Ålice <3"}
+	name := func(userID string) string {
+		if n, ok := names[userID]; ok {
+			return n
+		}
+		return userID
+	}
+	sr := StatsReport{Rows: []StatsRow{{Name: "@alice:test.com", Winner: 1}, {Name: "bob"}}}
+
+	var buf strings.Builder
+	require.NoError(t, sr.WriteText(&buf, name))
+	lines := strings.Split(buf.String(), "\n")
+	require.Len(t, lines, 4)
+	assert.True(t, strings.HasPrefix(lines[1], "Ålice <3 : 0000"), lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], "bob      : 0000"), lines[2])
+
+	buf.Reset()
+	require.NoError(t, sr.WriteHTML(&buf, name))
+	assert.Contains(t, buf.String(), "<td><strong>Ålice &lt;3</strong></td>")
+
+	buf.Reset()
+	require.NoError(t, testRoundReport().WriteText(&buf, name))
+	assert.Contains(t, buf.String(), "#1 Ålice <3 [13:37:00:123000000]")
+	assert.Contains(t, buf.String(), "Ålice <3: inspection tax")
+
+	buf.Reset()
+	require.NoError(t, testRoundReport().WriteHTML(&buf, name))
+	assert.Contains(t, buf.String(), "<td><strong>Ålice &lt;3</strong></td>")
+	assert.Contains(t, buf.String(), "<li>Ålice &lt;3: late arrival, placed #1</li>")
+}
@@ -0,0 +1,148 @@
+package leet
+
+import (
+	"sort"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+)
+
+// RoundState tells where in the daily round lifecycle the game is
+type RoundState uint32
+
+// The states follow each other in this order, and then wraps around to RoundIdle again
+const (
+	RoundIdle        RoundState = iota // waiting for the next round
+	RoundOpen                          // entry window is open, but no entries yet
+	RoundCollecting                    // at least one entry received
+	RoundProvisional                   // entry window closed, provisional results out, but late arrivals are still accepted
+	RoundCalculating                   // entry window closed, results are being calculated
+	RoundAnnounced                     // results are announced, until the next round opens
+)
+
+const (
+	rsNameIdle        = `idle`
+	rsNameOpen        = `open`
+	rsNameCollecting  = `collecting`
+	rsNameProvisional = `provisional`
+	rsNameCalculating = `calculating`
+	rsNameAnnounced   = `announced`
+	rsNameInvalid     = `invalid`
+)
+
+func (rs RoundState) String() string {
+	switch rs {
+	case RoundIdle:
+		return rsNameIdle
+	case RoundOpen:
+		return rsNameOpen
+	case RoundCollecting:
+		return rsNameCollecting
+	case RoundProvisional:
+		return rsNameProvisional
+	case RoundCalculating:
+		return rsNameCalculating
+	case RoundAnnounced:
+		return rsNameAnnounced
+	default:
+		return rsNameInvalid
+	}
+}
+
+// roundEntry is what we remember about each accepted entry in the current round
+type roundEntry struct {
+	user   *User
+	origin time.Time // unadjusted timestamp from the sender's server
+	tfr    ltime.TimeFrameResult
+	points int // points given (or taken, if negative) for this entry, including bonus
+	bonus  int
+}
+
+type roundEntries []roundEntry
+
+// onTime returns the entries that were on time, sorted by closest to the target time first
+func (re roundEntries) onTime() roundEntries {
+	res := make(roundEntries, 0, len(re))
+	for _, e := range re {
+		if e.tfr.Code == ltime.TCOnTime {
+			res = append(res, e)
+		}
+	}
+	sort.SliceStable(
+		res,
+		func(i, j int) bool {
+			return res[i].tfr.Offset < res[j].tfr.Offset
+		},
+	)
+	return res
+}
+
+func (re roundEntries) nearMisses() roundEntries {
+	res := make(roundEntries, 0, len(re))
+	for _, e := range re {
+		if e.tfr.Code.NearMiss() {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// ranking returns the names of the users on time, in the order of placement
+func (re roundEntries) ranking() []string {
+	onTime := re.onTime()
+	names := make([]string, 0, len(onTime))
+	for _, e := range onTime {
+		names = append(names, e.user.Name)
+	}
+	return names
+}
+
+// report returns the results of the round, with the given heading
+func (re roundEntries) report(heading string, date time.Time) RoundReport {
+	rr := RoundReport{Heading: heading, Date: date}
+	for i, e := range re.onTime() {
+		rr.Results = append(
+			rr.Results,
+			RoundResult{
+				Rank:   i + 1,
+				Name:   e.user.Name,
+				TS:     e.tfr.TS,
+				Points: e.points - e.bonus,
+				Bonus:  e.bonus,
+				Total:  e.user.Scores.Total,
+				Done:   e.user.Done,
+			},
+		)
+	}
+	for _, e := range re.nearMisses() {
+		rr.NearMisses = append(
+			rr.NearMisses,
+			NearMiss{
+				Name:     e.user.Name,
+				Code:     e.tfr.Code,
+				MissedBy: e.tfr.MissedBy(),
+				Points:   e.points,
+				Total:    e.user.Scores.Total,
+			},
+		)
+	}
+	return rr
+}
+
+// rankChanges returns how the placements changed from the provisional to the final ranking,
+// due to entries arriving late.
+func rankChanges(provisional, final []string) []RankChange {
+	prevRank := make(map[string]int, len(provisional))
+	for i, name := range provisional {
+		prevRank[name] = i + 1
+	}
+
+	var changes []RankChange
+	for i, name := range final {
+		rank := i + 1
+		if prev := prevRank[name]; prev != rank {
+			changes = append(changes, RankChange{Name: name, From: prev, To: rank})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,207 @@
+package leet
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/util"
+)
+
+var ErrNoSimEntries = errors.New("no entries to simulate")
+
+// SimEntry is an entry to replay in a simulation
+type SimEntry struct {
+	User      string
+	Timestamp time.Time
+}
+
+// parseSimTime parses an RFC 3339 timestamp, or milliseconds since the epoch, like origin_server_ts in Matrix
+func parseSimTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func newSimEntry(user, ts string) (SimEntry, error) {
+	user = strings.TrimSpace(user)
+	if user == "" {
+		return SimEntry{}, errors.New("no user")
+	}
+	t, err := parseSimTime(ts)
+	if err != nil {
+		return SimEntry{}, err
+	}
+	return SimEntry{User: user, Timestamp: t}, nil
+}
+
+func readSimJSONL(r io.Reader) ([]SimEntry, error) {
+	var entries []SimEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var raw struct {
+			User      string          `json:"user"`
+			Timestamp json.RawMessage `json:"timestamp"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ts := string(raw.Timestamp)
+		if strings.HasPrefix(ts, `"`) {
+			if err := json.Unmarshal(raw.Timestamp, &ts); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		e, err := newSimEntry(raw.User, ts)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func readSimCSV(r io.Reader) ([]SimEntry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	var entries []SimEntry
+	for first := true; ; first = false {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && strings.EqualFold(strings.TrimSpace(rec[1]), "timestamp") {
+			continue // header
+		}
+		e, err := newSimEntry(rec[0], rec[1])
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// ReadSimEntries reads entries for a simulation, either as JSON lines with "user" and "timestamp",
+// or as CSV with user and timestamp columns, and an optional header.
+// Timestamps are RFC 3339, or milliseconds since the epoch.
+func ReadSimEntries(r io.Reader) ([]SimEntry, error) {
+	entries, err := readSimEntries(r)
+	if err == nil && len(entries) == 0 {
+		return nil, ErrNoSimEntries
+	}
+	return entries, err
+}
+
+func readSimEntries(r io.Reader) ([]SimEntry, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = br.ReadByte()
+			continue
+		case '{':
+			return readSimJSONL(br)
+		default:
+			return readSimCSV(br)
+		}
+	}
+}
+
+func simDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Simulate replays the entries as if they were played live, with a round per day, in the time zone of the
+// game. The results of each round, and then the stats, are written to w, and if verbose, also the reply
+// to each entry. Entries outside the entry window are skipped, as the bot won't pass them on.
+// Nothing is saved, so this is safe to run on a game loaded from the live config file.
+func (l *Leet) Simulate(ctx context.Context, w io.Writer, entries []SimEntry, verbose bool) error {
+	if l == nil {
+		return ErrNilReceiver
+	}
+	if len(entries) == 0 {
+		return ErrNoSimEntries
+	}
+
+	entries = slices.Clone(entries)
+	slices.SortStableFunc(entries, func(a, b SimEntry) int { return a.Timestamp.Compare(b.Timestamp) })
+
+	replies := io.Discard
+	if verbose {
+		replies = w
+	}
+
+	var (
+		day     time.Time
+		skipped int
+	)
+	closeRound := func() error {
+		rr, err := l.CloseRound(ctx, day)
+		if err != nil || rr.Empty() {
+			return err
+		}
+		return rr.WriteText(w, nil)
+	}
+	for _, e := range entries {
+		if date := simDate(l.tf.In(e.Timestamp)); !date.Equal(day) {
+			if !day.IsZero() {
+				if err := closeRound(); err != nil {
+					return err
+				}
+			}
+			day = date
+			l.OpenRound()
+		}
+
+		tfr := l.tf.Code(e.Timestamp)
+		if !tfr.Code.InsideWindow() {
+			skipped++
+			continue
+		}
+		if _, err := l.Play(ctx, replies, e.User, e.Timestamp, tfr); err != nil {
+			return err
+		}
+		if err := util.Fpf(replies, "\n"); err != nil {
+			return err
+		}
+	}
+	if err := closeRound(); err != nil {
+		return err
+	}
+
+	if skipped > 0 {
+		if err := util.Fpf(w, "Skipped %d entries outside the entry window\n", skipped); err != nil {
+			return err
+		}
+	}
+	stats, err := l.Stats()
+	if err != nil {
+		return err
+	}
+	return stats.WriteText(w, nil)
+}
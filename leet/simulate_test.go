@@ -0,0 +1,110 @@
+package leet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadSimEntries(t *testing.T) {
+	t.Parallel()
+
+	cet := time.FixedZone("", 3600)
+	want := []SimEntry{
+		{User: "alice", Timestamp: time.Date(2025, 1, 2, 13, 37, 0, 123000000, cet)},
+		{User: "bob", Timestamp: time.UnixMilli(1735821420456)},
+	}
+
+	for name, input := range map[string]string{
+		"csv":           "alice,2025-01-02T13:37:00.123+01:00\nbob, 1735821420456\n",
+		"csv header":    "user,timestamp\nalice,2025-01-02T13:37:00.123+01:00\nbob,1735821420456\n",
+		"jsonl":         "\n{\"user\": \"alice\", \"timestamp\": \"2025-01-02T13:37:00.123+01:00\"}\n\n{\"user\": \"bob\", \"timestamp\": 1735821420456}\n",
+		"jsonl strings": `{"user": "alice", "timestamp": "2025-01-02T13:37:00.123+01:00"}` + "\n" + `{"user": "bob", "timestamp": "1735821420456"}`,
+	} {
+		entries, err := ReadSimEntries(strings.NewReader(input))
+		require.NoError(t, err, name)
+		require.Len(t, entries, len(want), name)
+		for i := range want {
+			assert.Equal(t, want[i].User, entries[i].User, name)
+			assert.True(t, want[i].Timestamp.Equal(entries[i].Timestamp), name)
+		}
+	}
+
+	for input, wantErr := range map[string]string{
+		"":                                 ErrNoSimEntries.Error(),
+		" \n":                              ErrNoSimEntries.Error(),
+		"user,timestamp\n":                 ErrNoSimEntries.Error(),
+		"alice,13:37\n":                    "line 1: ",
+		"alice,2025-01-02T13:37:00Z\n,1\n": "line 2: no user",
+		"alice\n":                          "wrong number of fields",
+		"{\"user\": \"alice\"}\n":          "line 1: ",
+		"{\n":                              "line 1: ",
+	} {
+		_, err := ReadSimEntries(strings.NewReader(input))
+		require.Error(t, err, input)
+		assert.Contains(t, err.Error(), wantErr, input)
+	}
+}
+
+func Test_Leet_Simulate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	configFile := filepath.Join(t.TempDir(), "leet.json")
+	day := time.Date(2025, 1, 2, 13, 37, 0, 0, time.UTC)
+	l := New(
+		zerolog.Nop(),
+		Config{
+			ConfigFile: configFile,
+			TimeFrame: ltime.TimeFrame{
+				Hour:         13,
+				Minute:       37,
+				WindowBefore: time.Minute,
+				WindowAfter:  time.Minute,
+			},
+			Clock: ltime.NewFakeClock(day),
+		},
+	)
+	defer l.Close()
+
+	var buf strings.Builder
+	assert.ErrorIs(t, l.Simulate(ctx, &buf, nil, false), ErrNoSimEntries)
+
+	entries := []SimEntry{
+		// out of order on purpose, they are sorted by time
+		{User: "alice", Timestamp: day.Add(24*time.Hour + 500*time.Millisecond)},
+		{User: "alice", Timestamp: day.Add(123 * time.Millisecond)},
+		{User: "bob", Timestamp: day.Add(-100 * time.Millisecond)},
+		{User: "bob", Timestamp: day.Add(24*time.Hour + time.Hour)},
+	}
+	require.NoError(t, l.Simulate(ctx, &buf, entries, false))
+	assert.Equal(
+		t,
+		"Results for 2025-01-02:\n"+
+			"#1 alice [13:37:00:123000000] +3 = 3\n"+
+			"bob: too early by 100ms, 0 = 0\n"+
+			"Results for 2025-01-03:\n"+
+			"#1 alice [13:37:00:500000000] +3 = 6\n"+
+			"Skipped 1 entries outside the entry window\n"+
+			"Stats since 2025-01-02T13:37:00Z:\n"+
+			"alice : 0006 @ 2025-01-03 13:37:00.500000000 Best: 2025-01-02 13:37:00.123000000 Bonus: 000x = 0000 Tax: 000x = -0000 Miss: -0000\n"+
+			"bob   : 0000 @ 2025-01-02 13:36:59.900000000 Best: 2025-01-02 13:36:59.900000000 Bonus: 000x = 0000 Tax: 000x = -0000 Miss: -0000\n",
+		buf.String(),
+	)
+
+	// nothing is saved
+	_, err := os.Stat(configFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	buf.Reset()
+	require.NoError(t, l.Simulate(ctx, &buf, entries[:1], true))
+	assert.True(t, strings.HasPrefix(buf.String(), "[13:37:00:500000000]: alice - "), buf.String())
+}
@@ -0,0 +1,542 @@
+package leet
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteDriver = `sqlite3`
+
+var ErrNilStore = errors.New("store is nil")
+
+// migrations holds the schema changes, in order. The version of the schema is the number of migrations applied.
+// Never change a migration that has been released, add a new one instead.
+var migrations = []string{
+	// v1: initial schema
+	`
+CREATE TABLE leet_games (
+	game           TEXT PRIMARY KEY,
+	room           TEXT NOT NULL DEFAULT '',
+	bot_start      TEXT NOT NULL DEFAULT '',
+	inspection_tax INTEGER NOT NULL DEFAULT 0,
+	overshoot_tax  INTEGER NOT NULL DEFAULT 0,
+	inspect_always INTEGER NOT NULL DEFAULT 0,
+	tax_loners     INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE leet_bonus_configs (
+	game           TEXT NOT NULL REFERENCES leet_games(game) ON DELETE CASCADE,
+	position       INTEGER NOT NULL,
+	greeting       TEXT NOT NULL DEFAULT '',
+	sub_val        INTEGER NOT NULL,
+	step_points    INTEGER NOT NULL DEFAULT 0,
+	no_step_points INTEGER NOT NULL DEFAULT 0,
+	prefix_char    INTEGER NOT NULL DEFAULT 0,
+	use_step       INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (game, position)
+);
+CREATE TABLE leet_users (
+	game          TEXT NOT NULL REFERENCES leet_games(game) ON DELETE CASCADE,
+	name          TEXT NOT NULL,
+	done          INTEGER NOT NULL DEFAULT 0,
+	last_entry    TEXT NOT NULL DEFAULT '',
+	best_entry    TEXT NOT NULL DEFAULT '',
+	taxes_times   INTEGER NOT NULL DEFAULT 0,
+	taxes_total   INTEGER NOT NULL DEFAULT 0,
+	bonuses_times INTEGER NOT NULL DEFAULT 0,
+	bonuses_total INTEGER NOT NULL DEFAULT 0,
+	misses_times  INTEGER NOT NULL DEFAULT 0,
+	misses_total  INTEGER NOT NULL DEFAULT 0,
+	scores_times  INTEGER NOT NULL DEFAULT 0,
+	scores_total  INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (game, name)
+);
+CREATE TABLE leet_entries (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	game      TEXT NOT NULL REFERENCES leet_games(game) ON DELETE CASCADE,
+	user      TEXT NOT NULL,
+	round     TEXT NOT NULL,
+	ts        TEXT NOT NULL,
+	code      INTEGER NOT NULL,
+	offset_ns INTEGER NOT NULL,
+	points    INTEGER NOT NULL,
+	bonus     INTEGER NOT NULL
+);
+CREATE INDEX leet_entries_game_user ON leet_entries (game, user, ts);
+CREATE TABLE leet_taxes (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	game   TEXT NOT NULL REFERENCES leet_games(game) ON DELETE CASCADE,
+	user   TEXT NOT NULL,
+	round  TEXT NOT NULL,
+	kind   INTEGER NOT NULL,
+	amount INTEGER NOT NULL
+);
+`,
+	// v2: keep the unadjusted timestamp and the taxes paid with each entry, for history
+	`
+ALTER TABLE leet_entries ADD COLUMN origin_ts TEXT NOT NULL DEFAULT '';
+ALTER TABLE leet_entries ADD COLUMN tax INTEGER NOT NULL DEFAULT 0;
+`,
+	// v3: remember handled messages, so that they are not handled again when replayed
+	`
+CREATE TABLE leet_seen_messages (
+	message_id TEXT PRIMARY KEY,
+	room       TEXT NOT NULL,
+	sender     TEXT NOT NULL,
+	entry      INTEGER NOT NULL DEFAULT 0,
+	seen       INTEGER NOT NULL -- unix milliseconds, to be compared as numbers
+);
+CREATE INDEX leet_seen_messages_seen ON leet_seen_messages (seen);
+`,
+}
+
+// Store persists game state in SQLite.
+// All tables are prefixed with "leet_", so the same database file can be shared with the crypto store.
+type Store struct {
+	db *sql.DB
+}
+
+// OpenStore opens, or creates, the SQLite database at the given path, and brings the schema up to date
+func OpenStore(ctx context.Context, path string) (*Store, error) {
+	db, err := sql.Open(sqliteDriver, fmt.Sprintf("file:%s?_busy_timeout=5000&_foreign_keys=on", path))
+	if err != nil {
+		return nil, err
+	}
+	s := Store{db: db}
+	if err = s.migrate(ctx); err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+	return &s, nil
+}
+
+func (s *Store) Close() error {
+	if s == nil {
+		return ErrNilStore
+	}
+	return s.db.Close()
+}
+
+// inTx runs f in a transaction, which is committed if f returns nil, and rolled back otherwise
+func (s *Store) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func (s *Store) version(ctx context.Context) (int, error) {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS leet_version (version INTEGER NOT NULL)`); err != nil {
+		return 0, err
+	}
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT version FROM leet_version`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (s *Store) migrate(ctx context.Context) error {
+	version, err := s.version(ctx)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err = s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+				return fmt.Errorf("migration to version %d failed: %w", i+1, err)
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM leet_version`); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO leet_version (version) VALUES (?)`, i+1)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Games returns the keys of all games in the store
+func (s *Store) Games(ctx context.Context) ([]string, error) {
+	if s == nil {
+		return nil, ErrNilStore
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT game FROM leet_games ORDER BY game`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []string
+	for rows.Next() {
+		var game string
+		if err = rows.Scan(&game); err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+	return games, rows.Err()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// load reads the state of the given game into db.
+// It returns false if the game is not in the store.
+func (s *Store) load(ctx context.Context, game string, db *DB) (bool, error) {
+	if s == nil {
+		return false, ErrNilStore
+	}
+
+	loaded := DB{Users: UserData{Users: make(map[string]*User)}}
+	var botStart string
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT room, bot_start, inspection_tax, overshoot_tax, inspect_always, tax_loners FROM leet_games WHERE game = ?`,
+		game,
+	).Scan(
+		&loaded.Room,
+		&botStart,
+		&loaded.GameCfg.InspectionTax,
+		&loaded.GameCfg.OvershootTax,
+		&loaded.GameCfg.InspectAlways,
+		&loaded.GameCfg.TaxLoners,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if loaded.BotStart, err = parseTime(botStart); err != nil {
+		return false, err
+	}
+
+	if loaded.BonusCfgs, err = s.loadBonusConfigs(ctx, game); err != nil {
+		return false, err
+	}
+	if err = s.loadUsers(ctx, game, &loaded.Users); err != nil {
+		return false, err
+	}
+
+	db.replace(&loaded)
+
+	return true, nil
+}
+
+func (s *Store) loadBonusConfigs(ctx context.Context, game string) (BonusConfigs, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT greeting, sub_val, step_points, no_step_points, prefix_char, use_step
+		FROM leet_bonus_configs WHERE game = ? ORDER BY position`,
+		game,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bcs BonusConfigs
+	for rows.Next() {
+		var bc BonusConfig
+		if err = rows.Scan(&bc.Greeting, &bc.SubVal, &bc.StepPoints, &bc.NoStepPoints, &bc.PrefixChar, &bc.UseStep); err != nil {
+			return nil, err
+		}
+		bcs = append(bcs, bc)
+	}
+	return bcs, rows.Err()
+}
+
+func (s *Store) loadUsers(ctx context.Context, game string, ud *UserData) error {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT name, done, last_entry, best_entry, taxes_times, taxes_total, bonuses_times, bonuses_total,
+			misses_times, misses_total, scores_times, scores_total
+		FROM leet_users WHERE game = ?`,
+		game,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			u          User
+			last, best string
+		)
+		if err = rows.Scan(
+			&u.Name,
+			&u.Done,
+			&last,
+			&best,
+			&u.Taxes.Times,
+			&u.Taxes.Total,
+			&u.Bonuses.Times,
+			&u.Bonuses.Total,
+			&u.Missees.Times,
+			&u.Missees.Total,
+			&u.Scores.Times,
+			&u.Scores.Total,
+		); err != nil {
+			return err
+		}
+		if u.Entries.Last, err = parseTime(last); err != nil {
+			return err
+		}
+		if u.Entries.Best, err = parseTime(best); err != nil {
+			return err
+		}
+		ud.Users[u.Name] = &u
+	}
+	return rows.Err()
+}
+
+// save writes the full state of the game, and the entries and taxes from the last round, if any,
+// in a single transaction.
+func (s *Store) save(ctx context.Context, game, round string, db *DB, entries roundEntries, taxes taxEntries) error {
+	if s == nil {
+		return ErrNilStore
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := saveGame(ctx, tx, game, db); err != nil {
+			return err
+		}
+		if err := saveBonusConfigs(ctx, tx, game, db.BonusCfgs); err != nil {
+			return err
+		}
+		if err := saveUsers(ctx, tx, game, round == "", &db.Users); err != nil {
+			return err
+		}
+		if err := saveEntries(ctx, tx, game, round, entries, taxes); err != nil {
+			return err
+		}
+		return saveTaxes(ctx, tx, game, round, taxes)
+	})
+}
+
+func saveGame(ctx context.Context, tx *sql.Tx, game string, db *DB) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO leet_games (game, room, bot_start, inspection_tax, overshoot_tax, inspect_always, tax_loners)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (game) DO UPDATE SET
+			room = excluded.room,
+			bot_start = excluded.bot_start,
+			inspection_tax = excluded.inspection_tax,
+			overshoot_tax = excluded.overshoot_tax,
+			inspect_always = excluded.inspect_always,
+			tax_loners = excluded.tax_loners`,
+		game,
+		db.Room,
+		formatTime(db.BotStart),
+		db.GameCfg.InspectionTax,
+		db.GameCfg.OvershootTax,
+		db.GameCfg.InspectAlways,
+		db.GameCfg.TaxLoners,
+	)
+	return err
+}
+
+func saveBonusConfigs(ctx context.Context, tx *sql.Tx, game string, bcs BonusConfigs) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM leet_bonus_configs WHERE game = ?`, game); err != nil {
+		return err
+	}
+	for i, bc := range bcs {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO leet_bonus_configs (game, position, greeting, sub_val, step_points, no_step_points, prefix_char, use_step)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			game,
+			i,
+			bc.Greeting,
+			bc.SubVal,
+			bc.StepPoints,
+			bc.NoStepPoints,
+			bc.PrefixChar,
+			bc.UseStep,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveUsers writes the users of the game. With replace, which is for saves outside of rounds, like imports and
+// restores, users that are not in ud are removed, instead of being kept as they were.
+func saveUsers(ctx context.Context, tx *sql.Tx, game string, replace bool, ud *UserData) error {
+	ud.mu.RLock()
+	defer ud.mu.RUnlock()
+	if replace {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM leet_users WHERE game = ?`, game); err != nil {
+			return err
+		}
+	}
+	for _, u := range ud.Users {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO leet_users (game, name, done, last_entry, best_entry, taxes_times, taxes_total,
+				bonuses_times, bonuses_total, misses_times, misses_total, scores_times, scores_total)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (game, name) DO UPDATE SET
+				done = excluded.done,
+				last_entry = excluded.last_entry,
+				best_entry = excluded.best_entry,
+				taxes_times = excluded.taxes_times,
+				taxes_total = excluded.taxes_total,
+				bonuses_times = excluded.bonuses_times,
+				bonuses_total = excluded.bonuses_total,
+				misses_times = excluded.misses_times,
+				misses_total = excluded.misses_total,
+				scores_times = excluded.scores_times,
+				scores_total = excluded.scores_total`,
+			game,
+			u.Name,
+			u.Done,
+			formatTime(u.Entries.Last),
+			formatTime(u.Entries.Best),
+			u.Taxes.Times,
+			u.Taxes.Total,
+			u.Bonuses.Times,
+			u.Bonuses.Total,
+			u.Missees.Times,
+			u.Missees.Total,
+			u.Scores.Times,
+			u.Scores.Total,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveEntries(ctx context.Context, tx *sql.Tx, game, round string, entries roundEntries, taxes taxEntries) error {
+	taxByUser := taxes.byUser()
+	for _, e := range entries {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO leet_entries (game, user, round, origin_ts, ts, code, offset_ns, points, bonus, tax)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			game,
+			e.user.Name,
+			round,
+			formatTime(e.origin),
+			formatTime(e.tfr.TS),
+			e.tfr.Code,
+			e.tfr.Offset,
+			e.points,
+			e.bonus,
+			taxByUser[e.user.Name],
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveTaxes(ctx context.Context, tx *sql.Tx, game, round string, taxes taxEntries) error {
+	for _, t := range taxes {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO leet_taxes (game, user, round, kind, amount) VALUES (?, ?, ?, ?, ?)`,
+			game,
+			t.user.Name,
+			round,
+			t.kind,
+			t.amount,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SeenMessage is a message that has been handled, by its transport specific ID
+type SeenMessage struct {
+	ID     string
+	Room   string
+	Sender string
+	Entry  bool // the message was an entry in the game, and not some other command
+	Seen   time.Time
+}
+
+// MarkSeen remembers the message, and returns true if it was already seen, in which case it is left as it was
+func (s *Store) MarkSeen(ctx context.Context, msg SeenMessage) (bool, error) {
+	if s == nil {
+		return false, ErrNilStore
+	}
+	res, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO leet_seen_messages (message_id, room, sender, entry, seen) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_id) DO NOTHING`,
+		msg.ID,
+		msg.Room,
+		msg.Sender,
+		msg.Entry,
+		msg.Seen.UnixMilli(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 0, err
+}
+
+// Seen returns the message with the given ID, and false if it has not been seen
+func (s *Store) Seen(ctx context.Context, messageID string) (SeenMessage, bool, error) {
+	if s == nil {
+		return SeenMessage{}, false, ErrNilStore
+	}
+	msg := SeenMessage{ID: messageID}
+	var seen int64
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT room, sender, entry, seen FROM leet_seen_messages WHERE message_id = ?`,
+		messageID,
+	).Scan(&msg.Room, &msg.Sender, &msg.Entry, &seen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SeenMessage{}, false, nil
+	}
+	if err != nil {
+		return SeenMessage{}, false, err
+	}
+	msg.Seen = time.UnixMilli(seen)
+	return msg, true, nil
+}
+
+// ForgetSeen forgets messages seen before the given time, and returns how many there were
+func (s *Store) ForgetSeen(ctx context.Context, before time.Time) (int64, error) {
+	if s == nil {
+		return 0, ErrNilStore
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM leet_seen_messages WHERE seen < ?`, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,190 @@
+package leet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := OpenStore(context.Background(), filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func Test_OpenStore_migrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := OpenStore(ctx, path)
+	require.NoError(t, err)
+	version, err := s.version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+	require.NoError(t, s.Close())
+
+	// reopening should not try to apply migrations again
+	s, err = OpenStore(ctx, path)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	assert.ErrorIs(t, (*Store)(nil).Close(), ErrNilStore)
+}
+
+func Test_Store_saveLoad(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := testStore(t)
+
+	found, err := s.load(ctx, "game", &DB{})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	ts := time.Date(2025, 5, 12, 13, 37, 0, 1337, time.UTC)
+	u := &User{
+		Name:    "user",
+		Entries: ltime.EntryTime{Last: ts, Best: ts},
+		Scores:  ValueTracker{Times: 2, Total: 20},
+		Taxes:   ValueTracker{Times: 1, Total: 2},
+		Done:    true,
+	}
+	db := DB{
+		Room:      "!room:test.com",
+		BotStart:  ts.Add(-time.Hour),
+		GameCfg:   LeetConfig{InspectionTax: 10, OvershootTax: 5, InspectAlways: true, TaxLoners: true},
+		BonusCfgs: BonusConfigs{{Greeting: "Yay", SubVal: 1337, StepPoints: 2, PrefixChar: '0', UseStep: true}},
+		Users:     UserData{Users: map[string]*User{u.Name: u}},
+	}
+	round := roundEntries{{user: u, tfr: testTimeFrame().Code(ts), points: 11}}
+	taxes := taxEntries{{user: u, kind: taxInspection, amount: 2}}
+	require.NoError(t, s.save(ctx, "game", "2025-05-12", &db, round, taxes))
+	// saving again updates, instead of failing on conflicts
+	require.NoError(t, s.save(ctx, "game", "", &db, nil, nil))
+
+	loaded := DB{}
+	found, err = s.load(ctx, "game", &loaded)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, db.Room, loaded.Room)
+	assert.True(t, db.BotStart.Equal(loaded.BotStart))
+	assert.Equal(t, db.GameCfg, loaded.GameCfg)
+	assert.Equal(t, db.BonusCfgs, loaded.BonusCfgs)
+	require.Len(t, loaded.Users.Users, 1)
+	lu := loaded.Users.Users[u.Name]
+	assert.Equal(t, u.Scores, lu.Scores)
+	assert.Equal(t, u.Taxes, lu.Taxes)
+	assert.True(t, lu.Done)
+	assert.True(t, u.Entries.Best.Equal(lu.Entries.Best))
+
+	var count int
+	require.NoError(t, s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM leet_entries WHERE game = ?`, "game").Scan(&count))
+	assert.Equal(t, 1, count)
+	require.NoError(t, s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM leet_taxes WHERE game = ?`, "game").Scan(&count))
+	assert.Equal(t, 1, count)
+
+	games, err := s.Games(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"game"}, games)
+}
+
+func Test_Leet_Load_importsConfigFile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := testStore(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"room": "!room:test.com", "users": {"users": {"user": {"name": "user", "scores": {"times": 1, "total": 7}}}}}`), 0o600))
+
+	l := New(zerolog.Nop(), Config{Store: s, Key: "game", ConfigFile: path})
+	require.NoError(t, l.Load(ctx))
+	assert.Equal(t, 7, l.db.Users.getUser("user").Scores.Total)
+
+	// now it should come from the store, even if the file is gone
+	require.NoError(t, os.Remove(path))
+	l = New(zerolog.Nop(), Config{Store: s, Key: "game", ConfigFile: path})
+	require.NoError(t, l.Load(ctx))
+	assert.Equal(t, 7, l.db.Users.getUser("user").Scores.Total)
+	room, err := l.GetRoom()
+	require.NoError(t, err)
+	assert.Equal(t, "!room:test.com", room)
+}
+
+func Test_Leet_Save_replacesUsers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := testStore(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"users": {"users": {"alice": {"name": "alice"}, "bob": {"name": "bob"}}}}`), 0o600))
+	l := New(zerolog.Nop(), Config{Store: s, Key: "game", ConfigFile: path})
+	require.NoError(t, l.LoadConfigFile())
+	require.NoError(t, l.Save(ctx))
+
+	// importing fewer users should drop the others, not keep them as they were
+	require.NoError(t, os.WriteFile(path, []byte(`{"users": {"users": {"alice": {"name": "alice", "scores": {"times": 1, "total": 7}}}}}`), 0o600))
+	require.NoError(t, l.LoadConfigFile())
+	require.NoError(t, l.Save(ctx))
+
+	loaded := DB{}
+	found, err := s.load(ctx, "game", &loaded)
+	require.NoError(t, err)
+	assert.True(t, found)
+	require.Len(t, loaded.Users.Users, 1)
+	assert.Equal(t, 7, loaded.Users.Users["alice"].Scores.Total)
+}
+
+func Test_Store_seen(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := testStore(t)
+	ts := time.Date(2025, 5, 12, 13, 37, 0, 0, time.UTC)
+
+	_, found, err := s.Seen(ctx, "$1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	entry := SeenMessage{ID: "$1", Room: "!room", Sender: "@user", Entry: true, Seen: ts}
+	seen, err := s.MarkSeen(ctx, entry)
+	require.NoError(t, err)
+	assert.False(t, seen)
+
+	// the first time counts
+	seen, err = s.MarkSeen(ctx, SeenMessage{ID: "$1", Room: "!room", Sender: "@other", Seen: ts.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.True(t, seen)
+	msg, found, err := s.Seen(ctx, "$1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, entry.Sender, msg.Sender)
+	assert.True(t, msg.Entry)
+	assert.True(t, ts.Equal(msg.Seen))
+
+	seen, err = s.MarkSeen(ctx, SeenMessage{ID: "$2", Room: "!room", Sender: "@user", Seen: ts.AddDate(0, 0, 1)})
+	require.NoError(t, err)
+	assert.False(t, seen)
+
+	n, err := s.ForgetSeen(ctx, ts.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	_, found, err = s.Seen(ctx, "$1")
+	require.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = s.Seen(ctx, "$2")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	_, err = (*Store)(nil).MarkSeen(ctx, entry)
+	assert.ErrorIs(t, err, ErrNilStore)
+}
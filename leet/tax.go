@@ -0,0 +1,130 @@
+package leet
+
+// inspectionOdds is the N in the "1 in N" chance of the round winner being inspected,
+// when not configured to always inspect
+const inspectionOdds = 4
+
+type taxKind uint8
+
+const (
+	taxOvershoot taxKind = iota + 1
+	taxInspection
+	taxLoner
+)
+
+const (
+	tkNameOvershoot  = `overshoot`
+	tkNameInspection = `inspection`
+	tkNameLoner      = `loner`
+	tkNameInvalid    = `invalid`
+)
+
+func (tk taxKind) String() string {
+	switch tk {
+	case taxOvershoot:
+		return tkNameOvershoot
+	case taxInspection:
+		return tkNameInspection
+	case taxLoner:
+		return tkNameLoner
+	default:
+		return tkNameInvalid
+	}
+}
+
+// taxEntry is a record of a tax paid by a user at the end of a round
+type taxEntry struct {
+	user   *User
+	kind   taxKind
+	amount int // always positive, the amount subtracted from the user's score
+}
+
+type taxEntries []taxEntry
+
+func (te taxEntries) results() []TaxResult {
+	var res []TaxResult
+	for _, t := range te {
+		res = append(res, TaxResult{Name: t.user.Name, Kind: t.kind.String(), Amount: t.amount, Total: t.user.Scores.Total})
+	}
+	return res
+}
+
+// byUser returns the sum of taxes paid by each user
+func (te taxEntries) byUser() map[string]int {
+	res := make(map[string]int, len(te))
+	for _, t := range te {
+		res[t.user.Name] += t.amount
+	}
+	return res
+}
+
+// percentOf returns the given percentage of total, without going below 0 or above total
+func percentOf(total, percent int) int {
+	if total <= 0 || percent <= 0 {
+		return 0
+	}
+	return min(total*percent/100, total)
+}
+
+// pay subtracts the given amount from the user's score, and records it as a tax.
+// Nothing is recorded if there's nothing to pay.
+func (te taxEntries) pay(user *User, kind taxKind, amount int) taxEntries {
+	amount = min(amount, user.Scores.Total)
+	if amount <= 0 {
+		return te
+	}
+	user.Taxes.Add(amount)
+	user.Scores.Add(-amount)
+	return append(te, taxEntry{user: user, kind: kind, amount: amount})
+}
+
+// applyTaxes runs the tax phase at the end of a round, and then marks users reaching the target as done.
+// The given roll function should return a random number in [0, n).
+func (db *DB) applyTaxes(round roundEntries, target int, roll func(n int) int) taxEntries {
+	taxes := make(taxEntries, 0)
+	cfg := db.GameCfg
+
+	// Overshooting the target means the points for the round are lost, and you pay for trying
+	if cfg.OvershootTax > 0 {
+		for _, e := range round {
+			if e.points <= 0 || e.user.Scores.Total <= target {
+				continue
+			}
+			e.user.Scores.Undo(e.points)
+			e.user.Bonuses.Undo(e.bonus)
+			taxes = taxes.pay(e.user, taxOvershoot, cfg.OvershootTax)
+		}
+	}
+
+	if cfg.InspectionTax > 0 {
+		// only those on time are in the competition, so someone who missed doesn't keep a winner company
+		onTime := round.onTime()
+		switch {
+		case len(onTime) == 1 && cfg.TaxLoners:
+			// Playing alone is no real competition, so if configured, loners pay the inspection tax unconditionally
+			u := onTime[0].user
+			taxes = taxes.pay(u, taxLoner, percentOf(u.Scores.Total, cfg.InspectionTax))
+		case cfg.InspectAlways:
+			for _, e := range round {
+				taxes = taxes.pay(e.user, taxInspection, percentOf(e.user.Scores.Total, cfg.InspectionTax))
+			}
+		default:
+			// a loner is not picked for inspection, as there was nobody to win over
+			if len(onTime) > 1 && roll(inspectionOdds) == 0 {
+				u := onTime[0].user
+				taxes = taxes.pay(u, taxInspection, percentOf(u.Scores.Total, cfg.InspectionTax))
+			}
+		}
+	}
+
+	for _, e := range round {
+		if cfg.OvershootTax > 0 {
+			// you have to hit the target exactly, when overshooting is taxed
+			e.user.Done = e.user.Scores.Total == target
+		} else {
+			e.user.Done = e.user.Scores.Total >= target
+		}
+	}
+
+	return taxes
+}
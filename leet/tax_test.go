@@ -0,0 +1,169 @@
+package leet
+
+import (
+	"testing"
+
+	"github.com/oddlid/leetbot_matrix/ltime"
+	"github.com/stretchr/testify/assert"
+)
+
+func roundEntryFor(user *User, code ltime.TimeCode, points int) roundEntry {
+	user.Scores.Add(points)
+	return roundEntry{
+		user:   user,
+		tfr:    ltime.TimeFrameResult{Code: code},
+		points: points,
+	}
+}
+
+func Test_taxKind_String(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, tkNameOvershoot, taxOvershoot.String())
+	assert.Equal(t, tkNameInspection, taxInspection.String())
+	assert.Equal(t, tkNameLoner, taxLoner.String())
+	assert.Equal(t, tkNameInvalid, taxKind(0).String())
+}
+
+func Test_percentOf(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 0, percentOf(0, 10))
+	assert.Equal(t, 0, percentOf(100, 0))
+	assert.Equal(t, 10, percentOf(100, 10))
+	assert.Equal(t, 100, percentOf(100, 200))
+}
+
+func Test_DB_applyTaxes_done(t *testing.T) {
+	t.Parallel()
+
+	db := DB{}
+	a := &User{Name: "a", Scores: ValueTracker{Total: 1330}}
+	b := &User{Name: "b", Scores: ValueTracker{Total: 1330}}
+	round := roundEntries{
+		roundEntryFor(a, ltime.TCOnTime, 7),
+		roundEntryFor(b, ltime.TCOnTime, 10),
+	}
+
+	taxes := db.applyTaxes(round, 1337, func(int) int { return 0 })
+	assert.Empty(t, taxes)
+	assert.True(t, a.Done)
+	assert.True(t, b.Done)
+}
+
+func Test_DB_applyTaxes_overshoot(t *testing.T) {
+	t.Parallel()
+
+	db := DB{GameCfg: LeetConfig{OvershootTax: 5}}
+	a := &User{Name: "a", Scores: ValueTracker{Total: 1330}}
+	b := &User{Name: "b", Scores: ValueTracker{Total: 1330}}
+	round := roundEntries{
+		roundEntryFor(a, ltime.TCOnTime, 7),
+		roundEntryFor(b, ltime.TCOnTime, 10),
+	}
+
+	taxes := db.applyTaxes(round, 1337, func(int) int { return 1 })
+	assert.Len(t, taxes, 1)
+	assert.Equal(t, taxOvershoot, taxes[0].kind)
+	assert.True(t, a.Done)
+	assert.False(t, b.Done)
+	assert.Equal(t, 1325, b.Scores.Total)
+	assert.Equal(t, 5, b.Taxes.Total)
+	assert.Equal(t, 1, b.Taxes.Times)
+}
+
+func Test_DB_applyTaxes_inspection(t *testing.T) {
+	t.Parallel()
+
+	db := DB{GameCfg: LeetConfig{InspectionTax: 10}}
+	a := &User{Name: "a", Scores: ValueTracker{Total: 90}}
+	b := &User{Name: "b", Scores: ValueTracker{Total: 190}}
+	c := &User{Name: "c", Scores: ValueTracker{Total: 40}}
+	round := roundEntries{
+		roundEntryFor(a, ltime.TCOnTime, 10),
+		roundEntryFor(b, ltime.TCLate, 0),
+		roundEntryFor(c, ltime.TCOnTime, 10),
+	}
+	round[0].tfr.Offset = 1
+	round[2].tfr.Offset = 2
+
+	// no inspection this time
+	assert.Empty(t, db.applyTaxes(round, 1337, func(int) int { return 1 }))
+
+	// winner gets inspected
+	taxes := db.applyTaxes(round, 1337, func(int) int { return 0 })
+	assert.Len(t, taxes, 1)
+	assert.Equal(t, taxInspection, taxes[0].kind)
+	assert.Equal(t, a, taxes[0].user)
+	assert.Equal(t, 90, a.Scores.Total)
+
+	// everyone gets inspected
+	db.GameCfg.InspectAlways = true
+	taxes = db.applyTaxes(round, 1337, func(int) int { return 1 })
+	assert.Len(t, taxes, 3)
+	assert.Equal(t, 81, a.Scores.Total)
+	assert.Equal(t, 171, b.Scores.Total)
+	assert.Equal(t, 45, c.Scores.Total)
+
+	assert.Equal(
+		t,
+		[]TaxResult{
+			{Name: "a", Kind: tkNameInspection, Amount: 9, Total: 81},
+			{Name: "b", Kind: tkNameInspection, Amount: 19, Total: 171},
+			{Name: "c", Kind: tkNameInspection, Amount: 5, Total: 45},
+		},
+		taxes.results(),
+	)
+}
+
+func Test_DB_applyTaxes_loner(t *testing.T) {
+	t.Parallel()
+
+	db := DB{GameCfg: LeetConfig{InspectionTax: 50}}
+	a := &User{Name: "a", Scores: ValueTracker{Total: 90}}
+	b := &User{Name: "b", Scores: ValueTracker{Total: 90}}
+	// only a is on time, so b doesn't make it a competition
+	round := roundEntries{
+		roundEntryFor(a, ltime.TCOnTime, 10),
+		roundEntryFor(b, ltime.TCLate, 0),
+	}
+
+	// loners are left alone, unless configured otherwise
+	assert.Empty(t, db.applyTaxes(round, 1337, func(int) int { return 0 }))
+
+	db.GameCfg.TaxLoners = true
+	taxes := db.applyTaxes(round, 1337, func(int) int { return 1 })
+	assert.Len(t, taxes, 1)
+	assert.Equal(t, taxLoner, taxes[0].kind)
+	assert.Equal(t, a, taxes[0].user)
+	assert.Equal(t, 50, a.Scores.Total)
+	assert.Equal(t, 50, a.Taxes.Total)
+	assert.Equal(t, 90, b.Scores.Total)
+
+	// missing the window alone is not playing alone
+	missed := roundEntries{roundEntryFor(b, ltime.TCLate, 0)}
+	assert.Empty(t, db.applyTaxes(missed, 1337, func(int) int { return 0 }))
+}
+
+func Test_DB_applyTaxes_lonerInspectAlways(t *testing.T) {
+	t.Parallel()
+
+	db := DB{GameCfg: LeetConfig{InspectionTax: 50, InspectAlways: true}}
+	a := &User{Name: "a", Scores: ValueTracker{Total: 90}}
+	round := roundEntries{
+		roundEntryFor(a, ltime.TCOnTime, 10),
+	}
+
+	// without the loner tax, a single player is inspected like everyone else
+	taxes := db.applyTaxes(round, 1337, func(int) int { return 1 })
+	assert.Len(t, taxes, 1)
+	assert.Equal(t, taxInspection, taxes[0].kind)
+	assert.Equal(t, 50, a.Scores.Total)
+
+	// and with it, pays that instead
+	db.GameCfg.TaxLoners = true
+	taxes = db.applyTaxes(round, 1337, func(int) int { return 1 })
+	assert.Len(t, taxes, 1)
+	assert.Equal(t, taxLoner, taxes[0].kind)
+	assert.Equal(t, 25, a.Scores.Total)
+}
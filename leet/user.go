@@ -17,6 +17,21 @@ type User struct {
 	locked  atomic.Bool     // temp lock for spamming in a round
 }
 
+// clone returns a copy of u, which is needed since the lock can't be copied
+func (u *User) clone() *User {
+	c := &User{
+		Name:    u.Name,
+		Entries: u.Entries,
+		Taxes:   u.Taxes,
+		Bonuses: u.Bonuses,
+		Missees: u.Missees,
+		Scores:  u.Scores,
+		Done:    u.Done,
+	}
+	c.locked.Store(u.locked.Load())
+	return c
+}
+
 // Might be useful, if done a lot
 // func (u *User) lock(val bool) {
 // 	if u == nil {
@@ -29,27 +29,30 @@ func (ud *UserData) getUser(id string) *User {
 	// create new
 	ud.mu.Lock()
 	defer ud.mu.Unlock()
+	if ud.Users == nil {
+		ud.Users = make(map[string]*User)
+	}
 	u = &User{Name: id}
 	ud.Users[id] = u
 
 	return u
 }
 
-func (ud *UserData) maxNameLen() int {
+// unlockAll releases the per round lock for all users
+func (ud *UserData) unlockAll() {
 	if ud == nil {
-		return 0
+		return
 	}
-	max := 0
-	for _, v := range ud.Users {
-		nlen := len(v.Name)
-		if nlen > max {
-			max = nlen
-		}
+	ud.mu.RLock()
+	defer ud.mu.RUnlock()
+	for _, u := range ud.Users {
+		u.locked.Store(false)
 	}
-	return max
 }
 
 func (ud *UserData) toSlice() UserSlice {
+	ud.mu.RLock()
+	defer ud.mu.RUnlock()
 	s := make(UserSlice, 0, len(ud.Users))
 	for _, v := range ud.Users {
 		s = append(s, v)
@@ -58,6 +61,8 @@ func (ud *UserData) toSlice() UserSlice {
 }
 
 func (ud *UserData) filterByDone(done bool) UserSlice {
+	ud.mu.RLock()
+	defer ud.mu.RUnlock()
 	us := make(UserSlice, 0, len(ud.Users))
 	for _, v := range ud.Users {
 		if done == v.Done {
@@ -17,3 +17,14 @@ func (vt *ValueTracker) Add(value int) {
 		vt.Times++
 	}
 }
+
+// Undo reverts a previous call to Add with the same value.
+func (vt *ValueTracker) Undo(value int) {
+	if vt == nil {
+		return
+	}
+	if value != 0 {
+		vt.Total -= value
+		vt.Times--
+	}
+}
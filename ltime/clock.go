@@ -0,0 +1,60 @@
+package ltime
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time. Use RealClock, unless you want to control time, as in tests and simulations.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// RealClock is the system clock
+var RealClock Clock = realClock{}
+
+// ClockOrReal returns c, or RealClock if c is nil
+func ClockOrReal(c Clock) Clock {
+	if c == nil {
+		return RealClock
+	}
+	return c
+}
+
+// FakeClock is a clock that only moves when told to
+type FakeClock struct {
+	now time.Time
+	mu  sync.RWMutex
+}
+
+// NewFakeClock returns a clock stopped at t
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.now
+}
+
+// Set moves the clock to t, which may be in the past
+func (fc *FakeClock) Set(t time.Time) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.now = t
+}
+
+// Advance moves the clock d forward, and returns the new time
+func (fc *FakeClock) Advance(d time.Duration) time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.now = fc.now.Add(d)
+	return fc.now
+}
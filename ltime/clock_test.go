@@ -0,0 +1,35 @@
+package ltime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ClockOrReal(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, RealClock, ClockOrReal(nil))
+	fc := NewFakeClock(time.Time{})
+	assert.Equal(t, fc, ClockOrReal(fc))
+
+	before := time.Now()
+	now := ClockOrReal(nil).Now()
+	assert.False(t, now.Before(before))
+}
+
+func Test_FakeClock(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2025, 1, 2, 13, 37, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+	assert.Equal(t, start, fc.Now())
+	assert.Equal(t, start, fc.Now(), "should not move by itself")
+
+	assert.Equal(t, start.Add(time.Hour), fc.Advance(time.Hour))
+	assert.Equal(t, start.Add(time.Hour), fc.Now())
+
+	fc.Set(start)
+	assert.Equal(t, start, fc.Now())
+}
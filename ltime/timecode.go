@@ -5,11 +5,11 @@ type TimeCode uint8
 // Constants for signaling offset from time window
 const (
 	TCInvalid TimeCode = iota // Default/unspecified is not a valid value
-	TCBefore                  // more than a minute before
-	TCEarly                   // less than a minute before
-	TCOnTime                  // within correct minute
-	TCLate                    // less than a minute late
-	TCAfter                   // more than a minute late
+	TCBefore                  // before the entry window opens
+	TCEarly                   // in the entry window, before the target
+	TCOnTime                  // in the on time period from the target, the target minute by default
+	TCLate                    // in the entry window, after the on time period
+	TCAfter                   // after the entry window has closed
 )
 
 const (
@@ -7,22 +7,46 @@ import (
 
 const formatTFWindow = `15:04:05`
 
+// TimeFrame is when the game is played: the entry window opens WindowBefore the target, entries are on time
+// for OnTime from the target, and then late for WindowAfter, when the window closes
 type TimeFrame struct {
-	Hour         uint8         // target hour
-	Minute       uint8         // target minute
-	WindowBefore time.Duration // how long to consider "early" before the target minute
-	WindowAfter  time.Duration // how long to consider "late" after the target minute has ended
+	Hour         uint8          // target hour
+	Minute       uint8          // target minute
+	WindowBefore time.Duration  // how long to consider "early" before the target minute
+	OnTime       time.Duration  // how long to consider "on time" from the target, the whole target minute if 0
+	WindowAfter  time.Duration  // how long to consider "late" after the on time period has ended
+	Location     *time.Location // the time zone of the target, UTC if nil
 }
 
 // TimeFrameResult is a cached result of inputs and outputs from trying to score
 type TimeFrameResult struct {
 	TF     TimeFrame     // The TimeFrame this result was derived from
-	TS     time.Time     // The timestamp used to derive this result
+	TS     time.Time     // The timestamp used to derive this result, in the time zone of TF
 	Code   TimeCode      // Distance and direction indicator
 	Offset time.Duration // Offset from target time, unsigned (Code indicates before or after)
 }
 
+func (tf TimeFrame) onTime() time.Duration {
+	if tf.OnTime <= 0 {
+		return time.Minute
+	}
+	return tf.OnTime
+}
+
+func (tf TimeFrame) loc() *time.Location {
+	if tf.Location == nil {
+		return time.UTC
+	}
+	return tf.Location
+}
+
+// In returns t in the time zone of the target, which is what decides which day t is in the game
+func (tf TimeFrame) In(t time.Time) time.Time {
+	return t.In(tf.loc())
+}
+
 func (tf TimeFrame) Adjust(t time.Time, adjust time.Duration) TimeFrame {
+	t = tf.In(t)
 	then := time.Date(
 		t.Year(),
 		t.Month(),
@@ -38,37 +62,49 @@ func (tf TimeFrame) Adjust(t time.Time, adjust time.Duration) TimeFrame {
 		Hour:         uint8(when.Hour()),
 		Minute:       uint8(when.Minute()),
 		WindowBefore: tf.WindowBefore,
+		OnTime:       tf.OnTime,
 		WindowAfter:  tf.WindowAfter,
+		Location:     tf.Location,
 	}
 }
 
+// FormatWindowBefore returns when the entry window opens on the day of t, in the time zone of the target
 func (tf TimeFrame) FormatWindowBefore(t time.Time) string {
-	wb := tf.Adjust(t, -tf.WindowBefore)
-	return time.Date(0, 0, 0, int(wb.Hour), int(wb.Minute), 0, 0, time.Local).Format(formatTFWindow)
+	return tf.Target(t).Add(-tf.WindowBefore).Format(formatTFWindow)
 }
 
+// FormatWindowAfter returns when the entry window closes on the day of t, in the time zone of the target
 func (tf TimeFrame) FormatWindowAfter(t time.Time) string {
-	wa := tf.Adjust(t, tf.WindowAfter*2) // account for the whole minte of being on time
-	return time.Date(0, 0, 0, int(wa.Hour), int(wa.Minute), 0, 0, time.Local).Format(formatTFWindow)
+	return tf.WindowEnd(t).Format(formatTFWindow)
 }
 
+// AsCronSpec returns a daily cron spec (with seconds) for the target, in its time zone
 func (tf TimeFrame) AsCronSpec() string {
-	return fmt.Sprintf("0 %d %d * * *", tf.Minute, tf.Hour)
+	return fmt.Sprintf("CRON_TZ=%s 0 %d %d * * *", tf.loc(), tf.Minute, tf.Hour)
+}
+
+// CronSpecAt returns a daily cron spec (with seconds) for the time of day of t, in the time zone of t
+func CronSpecAt(t time.Time) string {
+	return fmt.Sprintf("CRON_TZ=%s %d %d %d * * *", t.Location(), t.Second(), t.Minute(), t.Hour())
+}
+
+// Target returns the target time for the day of t, where days are in the time zone of the target.
+// On days when the clocks change, it's still the same time of day on the wall clock.
+func (tf TimeFrame) Target(t time.Time) time.Time {
+	t = tf.In(t)
+	return time.Date(t.Year(), t.Month(), t.Day(), int(tf.Hour), int(tf.Minute), 0, 0, t.Location())
+}
+
+// WindowEnd returns the time when the entry window closes for the day of t
+func (tf TimeFrame) WindowEnd(t time.Time) time.Time {
+	return tf.Target(t).Add(tf.onTime() + tf.WindowAfter)
 }
 
 // Code returns a TimeFrameResult indicating if the actual time is before or after the target time,
 // and the distance to the target time
 func (tf TimeFrame) Code(actual time.Time) TimeFrameResult {
-	target := time.Date(
-		actual.Year(),
-		actual.Month(),
-		actual.Day(),
-		int(tf.Hour),
-		int(tf.Minute),
-		0, // Should be 0 since that's the target time we're aiming for
-		0, // -- || --
-		actual.Location(),
-	)
+	actual = tf.In(actual)
+	target := tf.Target(actual)
 
 	isBefore := actual.Before(target)
 
@@ -89,9 +125,9 @@ func (tf TimeFrame) Code(actual time.Time) TimeFrameResult {
 		} else {
 			result.Code = TCEarly
 		}
-	} else if distance >= tf.WindowAfter*2 { // x2 because the first whole minute is within On Time
+	} else if distance >= tf.onTime()+tf.WindowAfter {
 		result.Code = TCAfter
-	} else if distance >= tf.WindowAfter {
+	} else if distance >= tf.onTime() {
 		result.Code = TCLate
 	} else {
 		result.Code = TCOnTime
@@ -100,8 +136,21 @@ func (tf TimeFrame) Code(actual time.Time) TimeFrameResult {
 	return result
 }
 
-// GetTargetScore returns how many points needed to win the game, depending on the TimeFrame
-// configuration.
+// MissedBy returns how far outside the on time period the result is.
+// For results that are on time, it returns 0.
+func (tfr TimeFrameResult) MissedBy() time.Duration {
+	switch tfr.Code {
+	case TCBefore, TCEarly:
+		return tfr.Offset
+	case TCLate, TCAfter:
+		return tfr.Offset - tfr.TF.onTime()
+	default:
+		return 0
+	}
+}
+
+// GetTargetScore returns how many points needed to win the game, which is the target time read as a number,
+// like 1337 for 13:37. It only depends on the target, so changing the windows doesn't change the goal.
 func (tf TimeFrame) GetTargetScore() int {
 	return int(tf.Hour)*100 + int(tf.Minute)
 }
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_TimeFrame_Adjust(t *testing.T) {
@@ -27,7 +28,92 @@ func Test_TimeFrame_Adjust(t *testing.T) {
 
 func Test_TimeFrame_AsCronSpec(t *testing.T) {
 	t.Parallel()
-	assert.Equal(t, "0 37 13 * * *", TimeFrame{Hour: 13, Minute: 37}.AsCronSpec())
+	assert.Equal(t, "CRON_TZ=UTC 0 37 13 * * *", TimeFrame{Hour: 13, Minute: 37}.AsCronSpec())
+
+	loc, err := time.LoadLocation("Europe/Stockholm")
+	require.NoError(t, err)
+	assert.Equal(t, "CRON_TZ=Europe/Stockholm 0 37 13 * * *", TimeFrame{Hour: 13, Minute: 37, Location: loc}.AsCronSpec())
+}
+
+func Test_CronSpecAt(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "CRON_TZ=UTC 30 39 13 * * *", CronSpecAt(time.Date(2025, 5, 12, 13, 39, 30, 0, time.UTC)))
+}
+
+func Test_TimeFrame_WindowEnd(t *testing.T) {
+	t.Parallel()
+
+	tf := TimeFrame{Hour: 13, Minute: 37, WindowAfter: time.Minute}
+	now := time.Date(2025, 5, 12, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2025, 5, 12, 13, 37, 0, 0, time.UTC), tf.Target(now))
+	assert.Equal(t, time.Date(2025, 5, 12, 13, 39, 0, 0, time.UTC), tf.WindowEnd(now))
+}
+
+func Test_TimeFrame_Location(t *testing.T) {
+	t.Parallel()
+
+	loc, err := time.LoadLocation("Europe/Stockholm")
+	require.NoError(t, err)
+	tf := TimeFrame{Hour: 13, Minute: 37, WindowBefore: time.Minute, WindowAfter: time.Minute, Location: loc}
+
+	// timestamps as on a host running in UTC
+	summer := time.Date(2025, 7, 1, 11, 37, 30, 0, time.UTC)
+	res := tf.Code(summer)
+	assert.Equal(t, TCOnTime, res.Code)
+	assert.Equal(t, 30*time.Second, res.Offset)
+	assert.Equal(t, loc, res.TS.Location())
+	assert.Equal(t, 13, res.TS.Hour())
+	assert.Equal(t, "13:36:00", tf.FormatWindowBefore(summer))
+	assert.Equal(t, "13:39:00", tf.FormatWindowAfter(summer))
+
+	winter := time.Date(2025, 1, 1, 12, 37, 30, 0, time.UTC)
+	assert.Equal(t, TCOnTime, tf.Code(winter).Code)
+	assert.Equal(t, TCBefore, tf.Code(winter.Add(-time.Hour)).Code)
+
+	// the day is that of the target's time zone, not that of the timestamp
+	lateUTC := time.Date(2025, 7, 1, 23, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2025, 7, 2, 13, 37, 0, 0, loc), tf.Target(lateUTC))
+	assert.Equal(t, time.Date(2025, 7, 1, 13, 37, 0, 0, time.UTC), TimeFrame{Hour: 13, Minute: 37}.Target(lateUTC.In(loc)))
+}
+
+func Test_TimeFrame_DST(t *testing.T) {
+	t.Parallel()
+
+	loc, err := time.LoadLocation("Europe/Stockholm")
+	require.NoError(t, err)
+	tf := TimeFrame{Hour: 13, Minute: 37, WindowBefore: time.Minute, WindowAfter: time.Minute, Location: loc}
+
+	tests := []struct {
+		name      string
+		dayBefore time.Time
+		targetUTC time.Time
+	}{
+		{
+			name:      "clocks go forward",
+			dayBefore: time.Date(2025, 3, 29, 8, 0, 0, 0, loc),
+			targetUTC: time.Date(2025, 3, 30, 11, 37, 0, 0, time.UTC),
+		},
+		{
+			name:      "clocks go back",
+			dayBefore: time.Date(2025, 10, 25, 8, 0, 0, 0, loc),
+			targetUTC: time.Date(2025, 10, 26, 12, 37, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// the wall clock time of the target stays the same, even though the day isn't 24 hours
+			switchDay := tt.dayBefore.AddDate(0, 0, 1)
+			target := tf.Target(switchDay)
+			assert.True(t, tt.targetUTC.Equal(target), "target %s", target)
+			assert.NotEqual(t, 24*time.Hour, target.Sub(tf.Target(tt.dayBefore)))
+			assert.Equal(t, TCOnTime, tf.Code(tt.targetUTC).Code)
+			assert.Equal(t, TCEarly, tf.Code(tt.targetUTC.Add(-time.Second)).Code)
+			assert.Equal(t, TCLate, tf.Code(tt.targetUTC.Add(time.Minute)).Code)
+			assert.True(t, tt.targetUTC.Add(2*time.Minute).Equal(tf.WindowEnd(switchDay)))
+		})
+	}
 }
 
 func Test_TimeFrame_Code(t *testing.T) {
@@ -91,6 +177,44 @@ func Test_TimeFrame_Code(t *testing.T) {
 	assert.Equal(t, 59*time.Second, res.Offset)
 }
 
+func Test_TimeFrame_Code_windows(t *testing.T) {
+	t.Parallel()
+
+	tf := TimeFrame{
+		Hour:         13,
+		Minute:       37,
+		WindowBefore: 30 * time.Second,
+		OnTime:       2 * time.Minute,
+		WindowAfter:  10 * time.Second,
+	}
+	target := tf.Target(time.Date(2025, 5, 12, 8, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		offset   time.Duration
+		code     TimeCode
+		missedBy time.Duration
+	}{
+		{-31 * time.Second, TCBefore, 31 * time.Second},
+		{-30 * time.Second, TCEarly, 30 * time.Second},
+		{0, TCOnTime, 0},
+		{119 * time.Second, TCOnTime, 0},
+		{2 * time.Minute, TCLate, 0},
+		{129 * time.Second, TCLate, 9 * time.Second},
+		{130 * time.Second, TCAfter, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		res := tf.Code(target.Add(tt.offset))
+		assert.Equal(t, tt.code, res.Code, tt.offset)
+		assert.Equal(t, tt.missedBy, res.MissedBy(), tt.offset)
+	}
+
+	assert.Equal(t, target.Add(130*time.Second), tf.WindowEnd(target))
+	assert.Equal(t, "13:36:30", tf.FormatWindowBefore(target))
+	assert.Equal(t, "13:39:10", tf.FormatWindowAfter(target))
+	assert.Equal(t, 1337, tf.GetTargetScore())
+	assert.Equal(t, tf, tf.Adjust(target, 0))
+}
+
 func Test_TimeFrame_GetTargetScore(t *testing.T) {
 	t.Parallel()
 
@@ -98,3 +222,19 @@ func Test_TimeFrame_GetTargetScore(t *testing.T) {
 	assert.Equal(t, 1214, TimeFrame{Hour: 12, Minute: 14}.GetTargetScore())
 	assert.Equal(t, 214, TimeFrame{Hour: 2, Minute: 14}.GetTargetScore())
 }
+
+func Test_TimeFrameResult_MissedBy(t *testing.T) {
+	t.Parallel()
+
+	tf := TimeFrame{
+		Hour:         13,
+		Minute:       37,
+		WindowBefore: time.Minute,
+		WindowAfter:  time.Minute,
+	}
+
+	assert.Equal(t, time.Second, tf.Code(time.Date(0, 0, 0, 13, 36, 59, 0, time.UTC)).MissedBy())
+	assert.Equal(t, time.Duration(0), tf.Code(time.Date(0, 0, 0, 13, 37, 30, 0, time.UTC)).MissedBy())
+	assert.Equal(t, 5*time.Second, tf.Code(time.Date(0, 0, 0, 13, 38, 5, 0, time.UTC)).MissedBy())
+	assert.Equal(t, time.Duration(0), TimeFrameResult{}.MissedBy())
+}
@@ -25,6 +25,12 @@ func FormatTimeStampSubSecond(w io.Writer, t time.Time) error {
 	return fpf(w, tmplTSSubSec, t.Second(), t.Nanosecond())
 }
 
+// FormatOffsetSubSecond is like FormatTimeStampSubSecond, for an offset from a whole minute.
+// Offsets of a minute or more keep counting the seconds, instead of starting over.
+func FormatOffsetSubSecond(w io.Writer, d time.Duration) error {
+	return fpf(w, tmplTSSubSec, int64(d/time.Second), int64(d%time.Second))
+}
+
 func FormatLongDate(t time.Time) string {
 	return t.Format("2006-01-02 15:04:05.000000000")
 }
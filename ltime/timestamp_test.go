@@ -24,6 +24,18 @@ func Test_FormatTimeStampSubSecond(t *testing.T) {
 	assert.Equal(t, "00000000000", buf.String())
 }
 
+func Test_FormatOffsetSubSecond(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	assert.NoError(t, FormatOffsetSubSecond(&buf, 5*time.Second+123*time.Millisecond))
+	assert.Equal(t, "05123000000", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, FormatOffsetSubSecond(&buf, 65*time.Second))
+	assert.Equal(t, "65000000000", buf.String())
+}
+
 func Test_GetAdjustedTime(t *testing.T) {
 	t.Parallel()
 
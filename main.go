@@ -18,7 +18,9 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // so that time zones can be loaded on hosts without a zoneinfo database
 
+	"github.com/oddlid/leetbot_matrix/bot"
 	"github.com/oddlid/leetbot_matrix/util"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
@@ -33,15 +35,45 @@ const (
 	defaultConfigFile  = `/tmp/leetbot_config.json`
 	defaultHour        = 13
 	defaultMinute      = 37
+	defaultGrace       = 0
+	defaultWindow      = time.Minute
+	defaultMaxSkew     = 10 * time.Second
+	defaultBackups     = 5
+	defaultPlayer      = `player`
+	defaultIRCServer   = `irc.libera.chat:6697`
+	transportMatrix    = `matrix`
+	transportIRC       = `irc`
+	transportConsole   = `console`
 	envServer          = `M_HOMESERVER`
+	envHomeserverURL   = `M_HOMESERVER_URL`
 	envUser            = `M_USER`
 	envPass            = `M_PASS`
 	envDB              = `M_DB`
 	envLogLevel        = `L_LOGLEVEL`
 	envHour            = `L_HOUR`
 	envMinute          = `L_MINUTE`
+	envTimeZone        = `L_TIMEZONE`
+	envEarly           = `L_EARLY`
+	envOnTime          = `L_ON_TIME`
+	envLate            = `L_LATE`
+	envExtraGames      = `L_EXTRA_GAMES`
 	envConfigFile      = `L_CONFIGFILE`
+	envGrace           = `L_GRACE`
+	envTrustedServers  = `L_TRUSTED_SERVERS`
+	envMaxSkew         = `L_MAX_SKEW`
+	envShared          = `L_SHARED`
+	envBackups         = `L_BACKUPS`
+	envFeedback        = `L_FEEDBACK`
+	envBacklog         = `L_BACKLOG`
+	envTransports      = `L_TRANSPORTS`
+	envIRCServer       = `I_SERVER`
+	envIRCTLS          = `I_TLS`
+	envIRCNick         = `I_NICK`
+	envIRCPass         = `I_PASS`
+	envIRCSASL         = `I_SASL`
+	envIRCChannels     = `I_CHANNELS`
 	optServer          = `server`
+	optHomeserverURL   = `homeserver-url`
 	optRoom            = `room`
 	optUser            = `user`
 	optPass            = `pass`
@@ -49,7 +81,34 @@ const (
 	optLogLevel        = `log-level`
 	optHour            = `hour`
 	optMinute          = `minute`
+	optTimeZone        = `timezone`
+	optEarly           = `early`
+	optOnTime          = `on-time`
+	optLate            = `late`
+	optExtraGame       = `extra-game`
 	optConfigFile      = `config`
+	optGrace           = `grace`
+	optTrustedServers  = `trusted-server`
+	optMaxSkew         = `max-skew`
+	optShared          = `shared`
+	optGame            = `game`
+	optLegacyFile      = `legacy-file`
+	optUserMapping     = `users`
+	optChannel         = `channel`
+	optBackups         = `backups`
+	optBackup          = `backup`
+	optTransport       = `transport`
+	optIRCServer       = `irc-server`
+	optIRCTLS          = `irc-tls`
+	optIRCNick         = `irc-nick`
+	optIRCPass         = `irc-pass`
+	optIRCSASL         = `irc-sasl`
+	optIRCChannel      = `irc-channel`
+	optEntries         = `entries`
+	optFresh           = `fresh`
+	optVerbose         = `verbose`
+	optFeedback        = `feedback`
+	optBacklog         = `backlog`
 )
 
 var (
@@ -73,6 +132,23 @@ func getVersion() string {
 	return time.Time{}.Format("2006-01-02_15:04:05")
 }
 
+func gameFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:     optGame,
+		Usage:    "Which `game` to use, either a room ID, or \"shared\" when the leaderboard is shared, and \"/name\" after that for an extra game",
+		Required: true,
+	}
+}
+
+func backupsFlag() cli.Flag {
+	return &cli.IntFlag{
+		Name:    optBackups,
+		Usage:   "How many `backups` of the config file to keep when it's replaced",
+		Value:   defaultBackups,
+		EnvVars: []string{envBackups},
+	}
+}
+
 func app() *cli.App {
 	return &cli.App{
 		Compiled:  getBuildDate(),
@@ -94,6 +170,11 @@ func app() *cli.App {
 				Value:   defaultHomeServer,
 				EnvVars: []string{envServer},
 			},
+			&cli.StringFlag{
+				Name:    optHomeserverURL,
+				Usage:   "Client API `URL`, if not found through .well-known on the server",
+				EnvVars: []string{envHomeserverURL},
+			},
 			&cli.StringFlag{
 				Name:    optRoom,
 				Aliases: []string{"r"},
@@ -140,13 +221,122 @@ func app() *cli.App {
 				Value:   defaultMinute,
 				EnvVars: []string{envMinute},
 			},
+			&cli.StringFlag{
+				Name:    optTimeZone,
+				Aliases: []string{"z"},
+				Usage:   "The IANA time `zone` of the hour and minute, like \"Europe/Stockholm\". \"Local\" is the zone of the host.",
+				Value:   "Local",
+				EnvVars: []string{envTimeZone},
+			},
+			&cli.DurationFlag{
+				Name:    optEarly,
+				Usage:   "How long before the target the entry window opens, for entries that are too early (`duration`)",
+				Value:   defaultWindow,
+				EnvVars: []string{envEarly},
+			},
+			&cli.DurationFlag{
+				Name:    optOnTime,
+				Usage:   "How long from the target entries are on time (`duration`)",
+				Value:   defaultWindow,
+				EnvVars: []string{envOnTime},
+			},
+			&cli.DurationFlag{
+				Name:    optLate,
+				Usage:   "How long after the on time period the entry window closes, for entries that are too late (`duration`)",
+				Value:   defaultWindow,
+				EnvVars: []string{envLate},
+			},
+			&cli.StringSliceFlag{
+				Name: optExtraGame,
+				Usage: "Also play a game with its own leaderboard, given as `name=HH:MM`, like \"evening=22:22\" for \"!2222\", " +
+					"with the same windows and time zone as the main game (repeatable)",
+				EnvVars: []string{envExtraGames},
+			},
 			&cli.PathFlag{
 				Name:    optConfigFile,
 				Aliases: []string{"c"},
-				Usage:   "Config file `path`",
+				Usage:   "JSON config file `path`, for import and export of game state",
 				Value:   defaultConfigFile,
 				EnvVars: []string{envConfigFile},
 			},
+			&cli.DurationFlag{
+				Name:    optGrace,
+				Aliases: []string{"g"},
+				Usage:   "How long after the entry window closes to still accept late arriving entries (`duration`)",
+				Value:   defaultGrace,
+				EnvVars: []string{envGrace},
+			},
+			&cli.StringSliceFlag{
+				Name:    optTrustedServers,
+				Aliases: []string{"T"},
+				Usage:   "Trust timestamps from this `server`, in addition to the bot's own server (repeatable)",
+				EnvVars: []string{envTrustedServers},
+			},
+			&cli.DurationFlag{
+				Name:    optMaxSkew,
+				Usage:   "Max difference between message timestamp and time of receipt (`duration`), 0 to disable",
+				Value:   defaultMaxSkew,
+				EnvVars: []string{envMaxSkew},
+			},
+			&cli.BoolFlag{
+				Name:    optShared,
+				Usage:   "Share one leaderboard between all rooms, instead of one per room",
+				EnvVars: []string{envShared},
+			},
+			&cli.StringFlag{
+				Name:    optFeedback,
+				Usage:   "How to respond to entries: \"reply\" with how it went, or \"react\" with an emoji",
+				Value:   string(bot.FeedbackReply),
+				EnvVars: []string{envFeedback},
+			},
+			&cli.StringFlag{
+				Name: optBacklog,
+				Usage: "What to do with entries for the last round that come in after it ended, when catching up after being away: " +
+					"\"discard\" or \"score\" them",
+				Value:   string(bot.BacklogDiscard),
+				EnvVars: []string{envBacklog},
+			},
+			&cli.StringSliceFlag{
+				Name:    optTransport,
+				Aliases: []string{"t"},
+				Usage: "Where to play: \"matrix\", \"irc\" or \"console\". Can be given more than once, to play in several places. " +
+					"With console, lines starting with \"<name> \" are from other players.",
+				Value:   cli.NewStringSlice(transportMatrix),
+				EnvVars: []string{envTransports},
+			},
+			&cli.StringFlag{
+				Name:    optIRCServer,
+				Usage:   "IRC server `host:port`",
+				Value:   defaultIRCServer,
+				EnvVars: []string{envIRCServer},
+			},
+			&cli.BoolFlag{
+				Name:    optIRCTLS,
+				Usage:   "Connect to the IRC server with TLS",
+				Value:   true,
+				EnvVars: []string{envIRCTLS},
+			},
+			&cli.StringFlag{
+				Name:    optIRCNick,
+				Usage:   "IRC `nick`",
+				Value:   defaultUser,
+				EnvVars: []string{envIRCNick},
+			},
+			&cli.StringFlag{
+				Name:    optIRCPass,
+				Usage:   "IRC `password`, for NickServ or SASL",
+				EnvVars: []string{envIRCPass},
+			},
+			&cli.BoolFlag{
+				Name:    optIRCSASL,
+				Usage:   "Authenticate with SASL instead of NickServ",
+				EnvVars: []string{envIRCSASL},
+			},
+			&cli.StringSliceFlag{
+				Name:    optIRCChannel,
+				Usage:   "IRC `channel` to join, can be given more than once",
+				EnvVars: []string{envIRCChannels},
+			},
 		},
 		Before: func(ctx *cli.Context) error {
 			zerolog.TimeFieldFormat = logTimeStampLayout
@@ -162,6 +352,81 @@ func app() *cli.App {
 			return nil
 		},
 		Action: botEntryPoint,
+		Commands: []*cli.Command{
+			{
+				Name:   "export",
+				Usage:  "Export a game from the database to the config file",
+				Flags:  []cli.Flag{gameFlag(), backupsFlag()},
+				Action: exportEntryPoint,
+			},
+			{
+				Name:   "import",
+				Usage:  "Import a game from the config file into the database, replacing it if it exists",
+				Flags:  []cli.Flag{gameFlag()},
+				Action: importEntryPoint,
+			},
+			{
+				Name:  "import-legacy",
+				Usage: "Import a channel from a dvdgbot 1337 file into the database, replacing the game if it exists",
+				Flags: []cli.Flag{
+					gameFlag(),
+					&cli.PathFlag{
+						Name:     optLegacyFile,
+						Usage:    "dvdgbot 1337 JSON `file` to import",
+						Required: true,
+					},
+					&cli.PathFlag{
+						Name:     optUserMapping,
+						Usage:    "JSON `file` mapping IRC nicks to Matrix user IDs, e.g. {\"nick\": \"@user:server\"}",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  optChannel,
+						Usage: "Which IRC `channel` to import, if the file has more than one",
+					},
+				},
+				Action: importLegacyEntryPoint,
+			},
+			{
+				Name:  "restore",
+				Usage: "Roll the config file back to a backup, or list the backups if none is given",
+				Flags: []cli.Flag{
+					backupsFlag(),
+					&cli.StringFlag{
+						Name:  optBackup,
+						Usage: "Which `backup` to restore, by file name or path",
+					},
+					&cli.StringFlag{
+						Name:  optGame,
+						Usage: "Also import the restored config file into the database as `game`",
+					},
+				},
+				Action: restoreEntryPoint,
+			},
+			{
+				Name: "simulate",
+				Usage: "Replay entries against the game in the config file, and show the results of each round and the " +
+					"final stats, without saving anything. Use it to try out bonus and tax settings.",
+				Flags: []cli.Flag{
+					&cli.PathFlag{
+						Name: optEntries,
+						Usage: "`file` with entries to replay, or - for stdin. Either CSV with user and timestamp " +
+							"columns, or JSON lines like {\"user\": \"@user:server\", \"timestamp\": \"2025-01-02T13:37:00.123+01:00\"}. " +
+							"Timestamps can also be milliseconds since the epoch.",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  optFresh,
+						Usage: "Start from an empty game, instead of loading the config file",
+					},
+					&cli.BoolFlag{
+						Name:  optVerbose,
+						Usage: "Also show the reply to each entry",
+					},
+				},
+				Action: simulateEntryPoint,
+			},
+		},
 	}
 }
 